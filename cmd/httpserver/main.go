@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/rizalta/httpone/internal/request"
 	"github.com/rizalta/httpone/internal/response"
 	"github.com/rizalta/httpone/internal/server"
 )
 
-const port = 42069
+const (
+	port            = 42069
+	shutdownTimeout = 10 * time.Second
+)
 
 var html400 = []byte(`<html>
   <head>
@@ -43,32 +52,85 @@ var html200 = []byte(`<html>
   </body>
 </html>`)
 
+func handler(w response.Writer, req *request.Request) {
+	var status response.StatusCode = response.StatusOK
+	var body []byte
+	w.Headers().Set("Content-Type", "text/html")
+	switch req.URL.Path {
+	case "/yourproblem":
+		status = response.StatusBadRequest
+		body = html400
+		w.WriteHeader(status)
+		w.Write(body)
+	case "/myproblem":
+		status = response.StatusInternalServerError
+		body = html500
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+// stringsFlag collects the values of a flag given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
 func main() {
-	server, err := server.Serve(port, func(w response.Writer, req *request.Request) {
-		var status response.StatusCode = response.StatusOK
-		var body []byte
-		w.Headers().Set("Content-Type", "text/html")
-		switch req.RequestLine.RequestTarget {
-		case "/yourproblem":
-			status = response.StatusBadRequest
-			body = html400
-			w.WriteHeader(status)
-			w.Write(body)
-		case "/myproblem":
-			status = response.StatusInternalServerError
-			body = html500
-			w.WriteHeader(status)
-			w.Write(body)
-		}
-	})
+	addr := flag.String("addr", fmt.Sprintf("localhost:%d", port), "listen address, host:port or unix:///path/to.sock")
+	socketMode := flag.String("socket-mode", "0660", "file mode of a unix socket, in octal")
+	var certFiles, keyFiles stringsFlag
+	flag.Var(&certFiles, "cert", "TLS certificate file, enables HTTPS together with -key; repeat for SNI")
+	flag.Var(&keyFiles, "key", "TLS key file, one for each -cert in the same order")
+	flag.Parse()
+
+	if len(certFiles) != len(keyFiles) {
+		log.Fatalf("Got %d -cert and %d -key flags, they must come in pairs", len(certFiles), len(keyFiles))
+	}
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		log.Fatalf("Invalid socket mode %q: %v", *socketMode, err)
+	}
+
+	cfg := server.Config{
+		Addr:              *addr,
+		SocketMode:        os.FileMode(mode),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	for i := range certFiles {
+		cfg.Certificates = append(cfg.Certificates, server.CertPair{CertFile: certFiles[i], KeyFile: keyFiles[i]})
+	}
+
+	var srv *server.Server
+	if len(cfg.Certificates) > 0 {
+		srv, err = cfg.ServeTLS("", "", handler)
+	} else {
+		srv, err = cfg.Serve(handler)
+	}
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", srv.Addr())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server stopped: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -4,49 +4,134 @@ package headers
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
-	"unicode"
 )
 
-type Headers map[string][]string
+// Field is a single header field line, with its name as it was given.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Headers holds header fields in the order they were added, keeping the
+// case of their names for writing them out again. Lookups ignore case.
+type Headers struct {
+	fields []Field
+}
+
+func (h *Headers) Get(name string) string {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Has reports whether the field is present, even with an empty value.
+func (h *Headers) Has(name string) bool {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns every value of the field in the order received, or nil.
+func (h *Headers) Values(name string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
 
-func (h Headers) Get(name string) string {
-	if values, ok := h[strings.ToLower(name)]; ok {
-		return values[0]
-	} else {
-		return ""
+// Combined returns the values of a list-based field joined with ", ", as
+// RFC 9110 section 5.3 allows. Cookie values are joined with "; " instead.
+// Set-Cookie cannot be combined, so only its first value is returned; use
+// Values for all of them.
+func (h *Headers) Combined(name string) string {
+	switch strings.ToLower(name) {
+	case "set-cookie":
+		return h.Get(name)
+	case "cookie":
+		return strings.Join(h.Values(name), "; ")
 	}
+	return strings.Join(h.Values(name), ", ")
 }
 
-func (h Headers) Set(name, value string) {
-	name = strings.ToLower(name)
-	h[name] = []string{value}
+// Set replaces the values of the field with value. The field keeps the
+// position of its first line but takes the case of name.
+func (h *Headers) Set(name, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.Name, name) {
+			h.fields[i] = Field{Name: name, Value: value}
+			rest := slices.DeleteFunc(h.fields[i+1:], func(f Field) bool {
+				return strings.EqualFold(f.Name, name)
+			})
+			h.fields = h.fields[:i+1+len(rest)]
+			return
+		}
+	}
+	h.Add(name, value)
 }
 
-func (h Headers) Add(name, value string) {
-	name = strings.ToLower(name)
-	h[name] = append(h[name], value)
+func (h *Headers) Add(name, value string) {
+	h.fields = append(h.fields, Field{Name: name, Value: value})
 }
 
-func NewHeaders() Headers {
-	return make(Headers)
+func (h *Headers) Del(name string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, name)
+	})
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// Fields returns a copy of the field lines in order.
+func (h *Headers) Fields() []Field {
+	return slices.Clone(h.fields)
+}
+
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
 var (
-	ErrMalformedHeader   = errors.New("malformed header")
-	ErrInvalidHeaderName = errors.New("invalid header name")
+	ErrMalformedHeader    = errors.New("malformed header")
+	ErrInvalidHeaderName  = errors.New("invalid header name")
+	ErrInvalidHeaderValue = errors.New("invalid header value")
+	ErrDuplicateHeader    = errors.New("duplicate header field")
 )
 
-func isToken(s string) bool {
+// singletonFields may appear at most once, since a second occurrence could
+// be read differently by another recipient.
+var singletonFields = map[string]struct{}{
+	"content-length": {},
+	"host":           {},
+}
+
+// IsToken reports whether s is a token as defined in RFC 9110: one or more
+// ASCII letters, digits or tchar symbols.
+func IsToken(s string) bool {
 	if len(s) == 0 {
 		return false
 	}
-	for _, r := range s {
-		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
 			continue
 		}
 
-		switch r {
+		switch c {
 		case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
 			continue
 		default:
@@ -56,9 +141,35 @@ func isToken(s string) bool {
 	return true
 }
 
+// ValidFieldValue reports whether v is a field-value as defined in RFC 9110
+// section 5.5: visible ASCII, obs-text, SP and HTAB, but no other control
+// characters.
+func ValidFieldValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckField checks a field before it is sent. Its name must be a token,
+// and its value must not contain CR, LF or NUL, which would end the field
+// line early and let the value forge more fields or a second response.
+func CheckField(f Field) error {
+	if !IsToken(f.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidHeaderName, f.Name)
+	}
+	if strings.ContainsAny(f.Value, "\r\n\x00") {
+		return fmt.Errorf("%w: %s", ErrInvalidHeaderValue, f.Name)
+	}
+	return nil
+}
+
 var crlf = []byte("\r\n")
 
-func (h Headers) Parse(data []byte) (int, bool, error) {
+func (h *Headers) Parse(data []byte) (int, bool, error) {
 	n := 0
 	idx := bytes.Index(data, crlf)
 
@@ -73,18 +184,32 @@ func (h Headers) Parse(data []byte) (int, bool, error) {
 	n += idx + len(crlf)
 	colonIdx := bytes.IndexByte(line, ':')
 
-	if colonIdx <= 0 || line[colonIdx-1] == ' ' {
+	// A bare CR or LF ends the line for some recipients but not others.
+	if bytes.ContainsAny(line, "\r\n") {
+		return 0, false, ErrMalformedHeader
+	}
+	if colonIdx <= 0 || line[colonIdx-1] == ' ' || line[colonIdx-1] == '\t' {
 		return 0, false, ErrMalformedHeader
 	}
 
-	name := bytes.ToLower(bytes.TrimSpace(line[:colonIdx]))
+	// The name is not trimmed: any whitespace around it, \v and \f included,
+	// is rejected by IsToken rather than read past.
+	name := line[:colonIdx]
 
-	if !isToken(string(name)) {
+	if !IsToken(string(name)) {
 		return 0, false, ErrInvalidHeaderName
 	}
 
-	value := bytes.TrimSpace(line[colonIdx+1:])
+	// Only OWS is trimmed, so that other whitespace at either end is
+	// checked and rejected with the rest of the value.
+	value := strings.Trim(string(line[colonIdx+1:]), " \t")
+	if !ValidFieldValue(value) {
+		return 0, false, ErrInvalidHeaderValue
+	}
 
-	h.Set(string(name), string(value))
+	if _, ok := singletonFields[strings.ToLower(string(name))]; ok && h.Has(string(name)) {
+		return 0, false, ErrDuplicateHeader
+	}
+	h.Add(string(name), value)
 	return n, false, nil
 }
@@ -36,13 +36,19 @@ func TestParseHeaders(t *testing.T) {
 
 	// Test: Valid header with extra spacing
 	headers = NewHeaders()
-	data = []byte("          Host: localhost:42069    \r\n\r\n")
+	data = []byte("Host:           localhost:42069    \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 37, n)
 	assert.False(t, done)
 
+	// Test: Whitespace before the name
+	for _, line := range []string{"          Host: localhost:42069", "\vHost: localhost", "Content-Length\f: 3", "Transfer-Encoding\v: chunked"} {
+		_, _, err = NewHeaders().Parse([]byte(line + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidHeaderName, line)
+	}
+
 	// Test: Valid 2 headers with existing headers
 	headers = NewHeaders()
 	headers.Set("host", "localhost:42069")
@@ -71,7 +77,7 @@ func TestParseHeaders(t *testing.T) {
 
 	// Test: Valid headers with digits and special charcters
 	headers = NewHeaders()
-	data = []byte("    X-Token_123!#$%&'*+.^`|~:       Testing     \r\n\r\n")
+	data = []byte("X-Token_123!#$%&'*+.^`|~:           Testing     \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	assert.False(t, done)
@@ -92,4 +98,111 @@ func TestParseHeaders(t *testing.T) {
 	}
 	assert.Contains(t, "notBar", headers.Get("foo"))
 	assert.Equal(t, 25, read)
+
+	// Test: Duplicate content-length
+	headers = NewHeaders()
+	data = []byte("Content-Length: 5\r\nContent-Length: 5\r\n\r\n")
+	n, _, err = headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.ErrorIs(t, err, ErrDuplicateHeader)
+
+	// Test: Bare LF inside a line
+	headers = NewHeaders()
+	data = []byte("Foo: bar\nContent-Length: 5\r\n\r\n")
+	_, _, err = headers.Parse(data)
+	require.ErrorIs(t, err, ErrMalformedHeader)
+
+	// Test: Set-Cookie is never combined
+	headers = NewHeaders()
+	data = []byte("Set-Cookie: a=1; Path=/\r\nSet-Cookie: b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n\r\n")
+	read = 0
+	for {
+		n, done, err := headers.Parse(data[read:])
+		require.NoError(t, err)
+		read += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"}, headers.Values("set-cookie"))
+	assert.Equal(t, "a=1; Path=/", headers.Combined("Set-Cookie"))
+}
+
+func TestHeadersOrder(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Host: example.com\r\nX-Custom-ID: 1\r\naccept: */*\r\nx-custom-id: 2\r\n\r\n")
+	read := 0
+	for {
+		n, done, err := h.Parse(data[read:])
+		require.NoError(t, err)
+		read += n
+		if done {
+			break
+		}
+	}
+
+	// Test: Wire order and case are kept
+	assert.Equal(t, []Field{
+		{"Host", "example.com"},
+		{"X-Custom-ID", "1"},
+		{"accept", "*/*"},
+		{"x-custom-id", "2"},
+	}, h.Fields())
+	assert.Equal(t, 4, h.Len())
+	assert.Equal(t, "1", h.Get("X-CUSTOM-ID"))
+	assert.True(t, h.Has("ACCEPT"))
+	assert.False(t, h.Has("cookie"))
+
+	// Test: Set keeps the position of the first line and drops the others
+	h.Set("x-Custom-Id", "3")
+	assert.Equal(t, []Field{
+		{"Host", "example.com"},
+		{"x-Custom-Id", "3"},
+		{"accept", "*/*"},
+	}, h.Fields())
+
+	// Test: Set of a new field appends it
+	h.Set("Content-Type", "text/plain")
+	assert.Equal(t, Field{"Content-Type", "text/plain"}, h.Fields()[3])
+
+	// Test: Del removes every line of the field
+	h.Add("ACCEPT", "text/html")
+	h.Del("Accept")
+	assert.Nil(t, h.Values("accept"))
+	assert.Equal(t, 3, h.Len())
+
+	// Test: Fields returns a copy
+	h.Fields()[0].Value = "changed"
+	assert.Equal(t, "example.com", h.Get("host"))
+}
+
+func TestFieldValidation(t *testing.T) {
+	// Test: Names must be ASCII tokens
+	for _, name := range []string{"Hö st", "Hést", "X Y", "X(Y)", "\u00e9"} {
+		_, _, err := NewHeaders().Parse([]byte(name + ": v\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidHeaderName, name)
+	}
+
+	// Test: Values with visible ASCII, obs-text, SP and HTAB
+	for _, value := range []string{"a b\tc", "caf\xc3\xa9", "\"quoted\", (comment)", ""} {
+		assert.True(t, ValidFieldValue(value), value)
+		_, _, err := NewHeaders().Parse([]byte("X: " + value + "\r\n\r\n"))
+		require.NoError(t, err, value)
+	}
+
+	// Test: Values with control characters
+	for _, value := range []string{"a\x00b", "a\x01b", "a\x1bb", "a\x7fb", "a\vb", "\vchunked", "3\v", "\f3"} {
+		assert.False(t, ValidFieldValue(value), value)
+		_, _, err := NewHeaders().Parse([]byte("X: " + value + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidHeaderValue, value)
+	}
+
+	// Test: Fields about to be sent
+	require.NoError(t, CheckField(Field{"Location", "/next?a=b c"}))
+	require.ErrorIs(t, CheckField(Field{"Location", "/\r\nSet-Cookie: evil=1"}), ErrInvalidHeaderValue)
+	require.ErrorIs(t, CheckField(Field{"Location", "/\n"}), ErrInvalidHeaderValue)
+	require.ErrorIs(t, CheckField(Field{"Location", "/\x00"}), ErrInvalidHeaderValue)
+	require.ErrorIs(t, CheckField(Field{"Bad Name", "v"}), ErrInvalidHeaderName)
+	require.ErrorIs(t, CheckField(Field{"X\r\nY", "v"}), ErrInvalidHeaderName)
 }
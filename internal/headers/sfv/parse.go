@@ -0,0 +1,356 @@
+package sfv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parser follows the parsing algorithms of RFC 8941 section 4.2.
+type parser struct {
+	s string
+	i int
+}
+
+func newParser(s string) *parser {
+	return &parser{s: strings.Trim(s, " ")}
+}
+
+func (p *parser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *parser) fail(what string) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrInvalidField, what, p.i)
+}
+
+func (p *parser) skipSP() {
+	for p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *parser) skipOWS() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.i++
+	}
+}
+
+// end checks that the whole field was parsed.
+func (p *parser) end() error {
+	if !p.eof() {
+		return p.fail("unexpected character")
+	}
+	return nil
+}
+
+// ParseItem parses an Item field value.
+func ParseItem(s string) (Item, error) {
+	p := newParser(s)
+	item, err := p.parseItem()
+	if err != nil {
+		return Item{}, err
+	}
+	return item, p.end()
+}
+
+// ParseList parses a List field value. An empty value is an empty list.
+func ParseList(s string) (List, error) {
+	p := newParser(s)
+	list := List{}
+	for !p.eof() {
+		member, err := p.parseItemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, member)
+
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// ParseDictionary parses a Dictionary field value. An empty value is an
+// empty dictionary. A key seen again overwrites the earlier value.
+func ParseDictionary(s string) (Dictionary, error) {
+	p := newParser(s)
+	dict := Dictionary{}
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var member Member
+		if p.peek() == '=' {
+			p.i++
+			member, err = p.parseItemOrInnerList()
+		} else {
+			var params Params
+			params, err = p.parseParams()
+			member = Item{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		dict.set(key, member)
+
+		if err := p.nextMember(); err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
+// nextMember moves past the comma between members of a list or dictionary.
+func (p *parser) nextMember() error {
+	p.skipOWS()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != ',' {
+		return p.fail("expected comma")
+	}
+	p.i++
+	p.skipOWS()
+	if p.eof() {
+		return p.fail("trailing comma")
+	}
+	return nil
+}
+
+func (p *parser) parseItemOrInnerList() (Member, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+	return p.parseItem()
+}
+
+func (p *parser) parseInnerList() (InnerList, error) {
+	p.i++
+	items := []Item{}
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.parseParams()
+			if err != nil {
+				return InnerList{}, err
+			}
+			return InnerList{Items: items, Params: params}, nil
+		}
+
+		item, err := p.parseItem()
+		if err != nil {
+			return InnerList{}, err
+		}
+		items = append(items, item)
+
+		if c := p.peek(); c != ' ' && c != ')' {
+			return InnerList{}, p.fail("expected space or ')'")
+		}
+	}
+	return InnerList{}, p.fail("unterminated inner list")
+}
+
+func (p *parser) parseItem() (Item, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	params, err := p.parseParams()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, Params: params}, nil
+}
+
+func (p *parser) parseParams() (Params, error) {
+	params := Params{}
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value any = true
+		if p.peek() == '=' {
+			p.i++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params.set(key, value)
+	}
+	return params, nil
+}
+
+func (p *parser) parseKey() (string, error) {
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.fail("invalid key")
+	}
+	start := p.i
+	for !p.eof() && isKeyChar(p.peek()) {
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *parser) parseBareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	}
+	return nil, p.fail("invalid bare item")
+}
+
+func (p *parser) parseNumber() (any, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.fail("invalid number")
+	}
+
+	digits := p.i
+	dot := -1
+	for !p.eof() {
+		c := p.peek()
+		if isDigit(c) {
+			p.i++
+		} else if c == '.' && dot < 0 {
+			if p.i-digits > 12 {
+				return nil, p.fail("decimal too long")
+			}
+			dot = p.i
+			p.i++
+		} else {
+			break
+		}
+		if dot < 0 && p.i-digits > 15 || dot >= 0 && p.i-digits > 16 {
+			return nil, p.fail("number too long")
+		}
+	}
+
+	num := p.s[start:p.i]
+	if dot < 0 {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	if frac := p.i - dot - 1; frac == 0 || frac > 3 {
+		return nil, p.fail("invalid decimal fraction")
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *parser) parseString() (string, error) {
+	p.i++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || p.peek() != '"' && p.peek() != '\\' {
+				return "", p.fail("invalid escape")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.fail("invalid string character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.fail("unterminated string")
+}
+
+func (p *parser) parseToken() Token {
+	start := p.i
+	p.i++
+	for !p.eof() && isTokenChar(p.peek()) {
+		p.i++
+	}
+	return Token(p.s[start:p.i])
+}
+
+func (p *parser) parseByteSequence() ([]byte, error) {
+	p.i++
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.fail("unterminated byte sequence")
+	}
+	content := p.s[p.i : p.i+end]
+	for i := 0; i < len(content); i++ {
+		if c := content[i]; !isAlpha(c) && !isDigit(c) && c != '+' && c != '/' && c != '=' {
+			return nil, p.fail("invalid byte sequence character")
+		}
+	}
+	p.i += end + 1
+
+	// Padding is synthesized when missing, as the RFC allows.
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(content, "="))
+	if err != nil {
+		return nil, p.fail("invalid base64")
+	}
+	return b, nil
+}
+
+func (p *parser) parseBoolean() (bool, error) {
+	p.i++
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+	return false, p.fail("invalid boolean")
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLCAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || 'A' <= c && c <= 'Z'
+}
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+// isTokenChar reports whether c may follow the first character of a
+// token: a tchar, ':' or '/'.
+func isTokenChar(c byte) bool {
+	if isAlpha(c) || isDigit(c) {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
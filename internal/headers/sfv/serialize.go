@@ -0,0 +1,219 @@
+package sfv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxInteger bounds integers to 15 digits.
+const maxInteger = 999_999_999_999_999
+
+// SerializeItem serializes an Item following RFC 8941 section 4.1.
+func SerializeItem(item Item) (string, error) {
+	var b strings.Builder
+	if err := writeItem(&b, item); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// SerializeList serializes a List. An empty list is the empty string.
+func SerializeList(list List) (string, error) {
+	var b strings.Builder
+	for i, member := range list {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeMember(&b, member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// SerializeDictionary serializes a Dictionary. An empty dictionary is the
+// empty string.
+func SerializeDictionary(dict Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range dict {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeKey(&b, m.Key); err != nil {
+			return "", err
+		}
+		// A true item is written as the key alone.
+		if item, ok := m.Member.(Item); ok && item.Value == true {
+			if err := writeParams(&b, item.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeMember(&b, m.Member); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func writeMember(b *strings.Builder, member Member) error {
+	switch m := member.(type) {
+	case Item:
+		return writeItem(b, m)
+	case InnerList:
+		return writeInnerList(b, m)
+	}
+	return fmt.Errorf("%w: member of type %T", ErrInvalidValue, member)
+}
+
+func writeInnerList(b *strings.Builder, list InnerList) error {
+	b.WriteByte('(')
+	for i, item := range list.Items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if err := writeItem(b, item); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return writeParams(b, list.Params)
+}
+
+func writeItem(b *strings.Builder, item Item) error {
+	if err := writeBareItem(b, item.Value); err != nil {
+		return err
+	}
+	return writeParams(b, item.Params)
+}
+
+func writeParams(b *strings.Builder, params Params) error {
+	for _, param := range params {
+		b.WriteByte(';')
+		if err := writeKey(b, param.Key); err != nil {
+			return err
+		}
+		if param.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, param.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeKey(b *strings.Builder, key string) error {
+	if key == "" || !isLCAlpha(key[0]) && key[0] != '*' {
+		return fmt.Errorf("%w: key %q", ErrInvalidValue, key)
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return fmt.Errorf("%w: key %q", ErrInvalidValue, key)
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+func writeBareItem(b *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case int:
+		return writeInteger(b, int64(v))
+	case int64:
+		return writeInteger(b, v)
+	case float64:
+		return writeDecimal(b, v)
+	case string:
+		return writeString(b, v)
+	case Token:
+		return writeToken(b, v)
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+		return nil
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: bare item of type %T", ErrInvalidValue, value)
+}
+
+func writeInteger(b *strings.Builder, v int64) error {
+	if v < -maxInteger || v > maxInteger {
+		return fmt.Errorf("%w: integer %d out of range", ErrInvalidValue, v)
+	}
+	b.WriteString(strconv.FormatInt(v, 10))
+	return nil
+}
+
+// writeDecimal rounds v to three decimal places, ties to even, and keeps
+// at least one. Rounding works on the shortest decimal form of v, so that
+// 0.0015 is a tie as written rather than as stored.
+func writeDecimal(b *strings.Builder, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("%w: decimal %v", ErrInvalidValue, v)
+	}
+
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(math.Abs(v), 'f', -1, 64), ".")
+	frac += "000"
+	if len(whole) > 12 {
+		return fmt.Errorf("%w: decimal %v out of range", ErrInvalidValue, v)
+	}
+	n, _ := strconv.ParseInt(whole+frac[:3], 10, 64)
+	if rest := frac[3:]; rest != "" && (rest[0] > '5' || rest[0] == '5' && (strings.Trim(rest[1:], "0") != "" || n%2 == 1)) {
+		n++
+	}
+	if n >= 1_000_000_000_000_000 {
+		return fmt.Errorf("%w: decimal %v out of range", ErrInvalidValue, v)
+	}
+
+	if v < 0 && n != 0 {
+		b.WriteByte('-')
+	}
+	s := strings.TrimRight(fmt.Sprintf("%d.%03d", n/1000, n%1000), "0")
+	if strings.HasSuffix(s, ".") {
+		s += "0"
+	}
+	b.WriteString(s)
+	return nil
+}
+
+func writeString(b *strings.Builder, v string) error {
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("%w: string character %#x", ErrInvalidValue, c)
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return nil
+}
+
+func writeToken(b *strings.Builder, v Token) error {
+	if v == "" || !isAlpha(v[0]) && v[0] != '*' {
+		return fmt.Errorf("%w: token %q", ErrInvalidValue, v)
+	}
+	for i := 1; i < len(v); i++ {
+		if !isTokenChar(v[i]) {
+			return fmt.Errorf("%w: token %q", ErrInvalidValue, v)
+		}
+	}
+	b.WriteString(string(v))
+	return nil
+}
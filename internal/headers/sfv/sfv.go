@@ -0,0 +1,159 @@
+// Package sfv parses and serializes Structured Field Values, RFC 8941.
+package sfv
+
+import (
+	"errors"
+
+	"github.com/rizalta/httpone/internal/headers"
+)
+
+var (
+	ErrInvalidField = errors.New("invalid structured field")
+	ErrInvalidValue = errors.New("value cannot be serialized")
+)
+
+// Token is a bare item of token type. Other bare items are int64 for
+// integers, float64 for decimals, string, []byte for byte sequences, and
+// bool.
+type Token string
+
+// Param is a parameter of an item or inner list.
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params are parameters in order. Keys are unique.
+type Params []Param
+
+// Get returns the value of the parameter with key.
+func (p Params) Get(key string) (any, bool) {
+	for _, param := range p {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set overwrites the value of key in place, or appends it.
+func (p *Params) set(key string, value any) {
+	for i := range *p {
+		if (*p)[i].Key == key {
+			(*p)[i].Value = value
+			return
+		}
+	}
+	*p = append(*p, Param{Key: key, Value: value})
+}
+
+// Member is a member of a List or Dictionary: an Item or an InnerList.
+type Member interface {
+	member()
+}
+
+// Item is a bare item with parameters.
+type Item struct {
+	Value  any
+	Params Params
+}
+
+// InnerList is a list of items with parameters of its own.
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+func (Item) member()      {}
+func (InnerList) member() {}
+
+// List is a list field.
+type List []Member
+
+// DictMember is a member of a Dictionary under its key.
+type DictMember struct {
+	Key    string
+	Member Member
+}
+
+// Dictionary is a dictionary field, in order. Keys are unique.
+type Dictionary []DictMember
+
+// Get returns the member with key.
+func (d Dictionary) Get(key string) (Member, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Member, true
+		}
+	}
+	return nil, false
+}
+
+// set overwrites the member of key in place, or appends it.
+func (d *Dictionary) set(key string, member Member) {
+	for i := range *d {
+		if (*d)[i].Key == key {
+			(*d)[i].Member = member
+			return
+		}
+	}
+	*d = append(*d, DictMember{Key: key, Member: member})
+}
+
+// GetItem parses the field name of h as an Item. Its lines are combined
+// first, as RFC 8941 section 4.2 asks, so a field sent twice fails.
+func GetItem(h *headers.Headers, name string) (Item, error) {
+	return ParseItem(h.Combined(name))
+}
+
+// GetList parses the lines of the field name of h as one List. A missing
+// field is an empty list.
+func GetList(h *headers.Headers, name string) (List, error) {
+	return ParseList(h.Combined(name))
+}
+
+// GetDictionary parses the lines of the field name of h as one Dictionary.
+// A missing field is an empty dictionary.
+func GetDictionary(h *headers.Headers, name string) (Dictionary, error) {
+	return ParseDictionary(h.Combined(name))
+}
+
+// SetItem serializes item into the field name of h.
+func SetItem(h *headers.Headers, name string, item Item) error {
+	s, err := SerializeItem(item)
+	if err != nil {
+		return err
+	}
+	h.Set(name, s)
+	return nil
+}
+
+// SetList serializes list into the field name of h. An empty list removes
+// the field, since it cannot be sent empty.
+func SetList(h *headers.Headers, name string, list List) error {
+	s, err := SerializeList(list)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		h.Del(name)
+		return nil
+	}
+	h.Set(name, s)
+	return nil
+}
+
+// SetDictionary serializes dict into the field name of h. An empty
+// dictionary removes the field.
+func SetDictionary(h *headers.Headers, name string, dict Dictionary) error {
+	s, err := SerializeDictionary(dict)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		h.Del(name)
+		return nil
+	}
+	h.Set(name, s)
+	return nil
+}
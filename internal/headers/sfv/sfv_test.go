@@ -0,0 +1,305 @@
+package sfv
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rizalta/httpone/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vector is a test case in the format of the structured-field-tests
+// repository. See testdata/README.md.
+type vector struct {
+	Name       string          `json:"name"`
+	Raw        []string        `json:"raw"`
+	HeaderType string          `json:"header_type"`
+	Expected   json.RawMessage `json:"expected"`
+	MustFail   bool            `json:"must_fail"`
+	CanFail    bool            `json:"can_fail"`
+	Canonical  []string        `json:"canonical"`
+}
+
+// upstreamDir holds a checkout of the structured-field-tests repository,
+// with its serialisation tests in the serialisation-tests subdirectory.
+const upstreamDir = "testdata/structured-field-tests"
+
+// loadVectors reads the cases of every file matching pattern. Files that
+// are not arrays of cases, such as a package.json, are left out.
+func loadVectors(t *testing.T, pattern string) map[string][]vector {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	require.NoError(t, err)
+
+	all := make(map[string][]vector, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			continue
+		}
+		var vectors []vector
+		require.NoError(t, json.Unmarshal(data, &vectors), file)
+		all[filepath.Base(file)] = vectors
+	}
+	return all
+}
+
+func TestParsing(t *testing.T) {
+	vectors := loadVectors(t, "testdata/rfc8941/*.json")
+	require.NotEmpty(t, vectors)
+	testParsing(t, vectors)
+}
+
+func TestSerialisation(t *testing.T) {
+	vectors := loadVectors(t, "testdata/rfc8941/serialisation/*.json")
+	require.NotEmpty(t, vectors)
+	testSerialisation(t, vectors)
+}
+
+func TestUpstreamParsing(t *testing.T) {
+	requireUpstream(t)
+	vectors := loadVectors(t, filepath.Join(upstreamDir, "*.json"))
+	require.NotEmpty(t, vectors, "UPSTREAM names a commit but %s has no cases", upstreamDir)
+	testParsing(t, vectors)
+}
+
+func TestUpstreamSerialisation(t *testing.T) {
+	requireUpstream(t)
+	vectors := loadVectors(t, filepath.Join(upstreamDir, "serialisation-tests", "*.json"))
+	require.NotEmpty(t, vectors, "UPSTREAM names a commit but %s/serialisation-tests has no cases", upstreamDir)
+	testSerialisation(t, vectors)
+}
+
+// requireUpstream reads the commit the upstream suite was vendored at from
+// the UPSTREAM file. The tests are skipped only while it names none.
+func requireUpstream(t *testing.T) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(upstreamDir, "UPSTREAM"))
+	require.NoError(t, err)
+	for _, line := range strings.Split(string(data), "\n") {
+		if commit, ok := strings.CutPrefix(line, "commit:"); ok {
+			commit = strings.TrimSpace(commit)
+			if commit == "none" {
+				t.Skip("structured-field-tests is not vendored, see testdata/README.md")
+			}
+			require.Regexp(t, "^[0-9a-f]{40}$", commit, "commit in UPSTREAM")
+			return
+		}
+	}
+	t.Fatal("UPSTREAM has no commit line")
+}
+
+func testParsing(t *testing.T, files map[string][]vector) {
+	for file, vectors := range files {
+		for _, v := range vectors {
+			t.Run(file+"/"+v.Name, func(t *testing.T) {
+				got, err := parse(v.HeaderType, v.Raw)
+				if v.MustFail {
+					require.ErrorIs(t, err, ErrInvalidField)
+					return
+				}
+				// Decoded first, so that cases of types this package does
+				// not implement are skipped rather than failed.
+				want := decode(t, v.HeaderType, v.Expected)
+				if err != nil && v.CanFail {
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, want, got)
+
+				canonical := strings.Join(v.Raw, ", ")
+				if v.Canonical != nil {
+					canonical = strings.Join(v.Canonical, ", ")
+				}
+				s, err := serialize(got)
+				require.NoError(t, err)
+				assert.Equal(t, canonical, s)
+			})
+		}
+	}
+}
+
+func testSerialisation(t *testing.T, files map[string][]vector) {
+	for file, vectors := range files {
+		for _, v := range vectors {
+			t.Run(file+"/"+v.Name, func(t *testing.T) {
+				s, err := serialize(decode(t, v.HeaderType, v.Expected))
+				if v.MustFail {
+					require.ErrorIs(t, err, ErrInvalidValue)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, strings.Join(v.Canonical, ", "), s)
+			})
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Add("Example-List", "a, (b c);x=1")
+	h.Add("example-list", `"d"`)
+
+	// Test: Lines of a field are parsed as one value
+	list, err := GetList(h, "Example-List")
+	require.NoError(t, err)
+	assert.Equal(t, List{
+		Item{Value: Token("a"), Params: Params{}},
+		InnerList{Items: []Item{{Value: Token("b"), Params: Params{}}, {Value: Token("c"), Params: Params{}}}, Params: Params{{"x", int64(1)}}},
+		Item{Value: "d", Params: Params{}},
+	}, list)
+
+	// Test: An Item sent twice is invalid
+	h.Add("Priority-Item", "1")
+	h.Add("Priority-Item", "2")
+	_, err = GetItem(h, "priority-item")
+	require.ErrorIs(t, err, ErrInvalidField)
+
+	// Test: A missing field is an empty dictionary
+	dict, err := GetDictionary(h, "Priority")
+	require.NoError(t, err)
+	assert.Empty(t, dict)
+
+	// Test: Set and read back
+	require.NoError(t, SetDictionary(h, "Priority", Dictionary{
+		{"u", Item{Value: 3}},
+		{"i", Item{Value: true}},
+	}))
+	assert.Equal(t, "u=3, i", h.Get("priority"))
+	dict, err = GetDictionary(h, "Priority")
+	require.NoError(t, err)
+	u, ok := dict.Get("u")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), u.(Item).Value)
+
+	require.NoError(t, SetItem(h, "Priority-Item", Item{Value: 1.5, Params: Params{{"a", Token("b")}}}))
+	assert.Equal(t, []string{"1.5;a=b"}, h.Values("priority-item"))
+
+	// Test: Empty lists and dictionaries remove the field
+	require.NoError(t, SetList(h, "Example-List", nil))
+	require.NoError(t, SetDictionary(h, "Priority", Dictionary{}))
+	assert.False(t, h.Has("example-list"))
+	assert.False(t, h.Has("priority"))
+
+	// Test: A value that cannot be serialized leaves the field alone
+	err = SetItem(h, "Priority-Item", Item{Value: "café"})
+	require.ErrorIs(t, err, ErrInvalidValue)
+	assert.Equal(t, "1.5;a=b", h.Get("priority-item"))
+}
+
+func parse(typ string, raw []string) (any, error) {
+	s := strings.Join(raw, ", ")
+	switch typ {
+	case "item":
+		return ParseItem(s)
+	case "list":
+		return ParseList(s)
+	case "dictionary":
+		return ParseDictionary(s)
+	}
+	panic("unknown header_type " + typ)
+}
+
+func serialize(v any) (string, error) {
+	switch v := v.(type) {
+	case Item:
+		return SerializeItem(v)
+	case List:
+		return SerializeList(v)
+	case Dictionary:
+		return SerializeDictionary(v)
+	}
+	panic("unknown value")
+}
+
+// decode turns the JSON form of an expected value into sfv types.
+func decode(t *testing.T, typ string, raw json.RawMessage) any {
+	t.Helper()
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v any
+	require.NoError(t, d.Decode(&v))
+
+	switch typ {
+	case "item":
+		return decodeItem(t, v)
+	case "list":
+		list := List{}
+		for _, m := range v.([]any) {
+			list = append(list, decodeMember(t, m))
+		}
+		return list
+	case "dictionary":
+		dict := Dictionary{}
+		for _, m := range v.([]any) {
+			pair := m.([]any)
+			dict = append(dict, DictMember{Key: pair[0].(string), Member: decodeMember(t, pair[1])})
+		}
+		return dict
+	}
+	t.Fatalf("unknown header_type %q", typ)
+	return nil
+}
+
+func decodeMember(t *testing.T, v any) Member {
+	pair := v.([]any)
+	items, ok := pair[0].([]any)
+	if !ok {
+		return decodeItem(t, v)
+	}
+	list := InnerList{Items: []Item{}, Params: decodeParams(t, pair[1])}
+	for _, item := range items {
+		list.Items = append(list.Items, decodeItem(t, item))
+	}
+	return list
+}
+
+func decodeItem(t *testing.T, v any) Item {
+	pair := v.([]any)
+	return Item{Value: decodeBareItem(t, pair[0]), Params: decodeParams(t, pair[1])}
+}
+
+func decodeParams(t *testing.T, v any) Params {
+	params := Params{}
+	for _, p := range v.([]any) {
+		pair := p.([]any)
+		params = append(params, Param{Key: pair[0].(string), Value: decodeBareItem(t, pair[1])})
+	}
+	return params
+}
+
+func decodeBareItem(t *testing.T, v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			f, err := v.Float64()
+			require.NoError(t, err)
+			return f
+		}
+		n, err := v.Int64()
+		require.NoError(t, err)
+		return n
+	case map[string]any:
+		switch v["__type"] {
+		case "token":
+			return Token(v["value"].(string))
+		case "binary":
+			b, err := base32.StdEncoding.DecodeString(v["value"].(string))
+			require.NoError(t, err)
+			return b
+		case "date", "displaystring":
+			t.Skipf("%s is an RFC 9651 type, not part of RFC 8941", v["__type"])
+		}
+	case string, bool:
+		return v
+	}
+	t.Fatalf("unknown bare item %v", v)
+	return nil
+}
@@ -0,0 +1,310 @@
+package headers
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidList      = errors.New("invalid list")
+	ErrInvalidMediaType = errors.New("invalid media type")
+	ErrInvalidDate      = errors.New("invalid date")
+	ErrInvalidQValue    = errors.New("invalid quality value")
+)
+
+// Quote returns s as a token if it is one, or else as a quoted-string.
+func Quote(s string) string {
+	if IsToken(s) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote returns the content of a quoted-string with its escapes removed.
+// Anything else is returned as it is.
+func Unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return "", ErrInvalidList
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			i++
+			if i == len(s)-1 {
+				return "", ErrInvalidList
+			}
+			c = s[i]
+		case '"':
+			return "", ErrInvalidList
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// split cuts s at every sep outside of quoted-strings and trims the
+// whitespace around each part.
+func split(s string, sep byte) ([]string, error) {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == sep:
+			parts = append(parts, strings.Trim(s[start:i], " \t"))
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, ErrInvalidList
+	}
+	return append(parts, strings.Trim(s[start:], " \t")), nil
+}
+
+// ParseList splits a comma-separated list, RFC 9110 section 5.6.1. Commas
+// inside quoted-strings do not split, and empty elements are dropped.
+// Elements are returned as sent, quotes included.
+func ParseList(s string) ([]string, error) {
+	parts, err := split(s, ',')
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(parts, func(p string) bool { return p == "" }), nil
+}
+
+// FormatList joins the elements of a list.
+func FormatList(elems []string) string {
+	return strings.Join(elems, ", ")
+}
+
+// parseParams parses name=value parameters, where the value is a token or
+// a quoted-string. Names are lowercased.
+func parseParams(parts []string, invalid error) (map[string]string, error) {
+	params := make(map[string]string, len(parts))
+	for _, p := range parts {
+		name, value, ok := strings.Cut(p, "=")
+		name = strings.ToLower(strings.TrimRight(name, " \t"))
+		value = strings.TrimLeft(value, " \t")
+		if !ok || !IsToken(name) {
+			return nil, invalid
+		}
+		if !strings.HasPrefix(value, `"`) && !IsToken(value) {
+			return nil, invalid
+		}
+		value, err := Unquote(value)
+		if err != nil {
+			return nil, invalid
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// formatParams writes parameters sorted by name, so that the output does
+// not depend on map order.
+func formatParams(b *strings.Builder, params map[string]string) {
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		b.WriteByte(';')
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(Quote(params[name]))
+	}
+}
+
+// MediaType is a media type with its parameters, such as the charset of a
+// text type or the boundary of a multipart one.
+type MediaType struct {
+	// Type is the type and subtype, lowercased, as in "text/html".
+	Type string
+	// Params holds the parameters by lowercased name.
+	Params map[string]string
+}
+
+// ParseMediaType parses a media type as used in Content-Type, RFC 9110
+// section 8.3.1.
+func ParseMediaType(s string) (MediaType, error) {
+	parts, err := split(s, ';')
+	if err != nil {
+		return MediaType{}, ErrInvalidMediaType
+	}
+	typ, subtype, ok := strings.Cut(parts[0], "/")
+	if !ok || !IsToken(typ) || !IsToken(subtype) {
+		return MediaType{}, ErrInvalidMediaType
+	}
+	params, err := parseParams(parts[1:], ErrInvalidMediaType)
+	if err != nil {
+		return MediaType{}, err
+	}
+	return MediaType{Type: strings.ToLower(parts[0]), Params: params}, nil
+}
+
+func (m MediaType) String() string {
+	var b strings.Builder
+	b.WriteString(m.Type)
+	formatParams(&b, m.Params)
+	return b.String()
+}
+
+// ContentType parses the Content-Type field.
+func (h *Headers) ContentType() (MediaType, error) {
+	return ParseMediaType(h.Get("content-type"))
+}
+
+func (h *Headers) SetContentType(m MediaType) {
+	h.Set("Content-Type", m.String())
+}
+
+// TimeFormat is the IMF-fixdate format that dates are sent in.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// dateFormats are the formats a recipient has to accept, RFC 9110 section
+// 5.6.7: IMF-fixdate and the obsolete RFC 850 and asctime formats.
+var dateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT",
+	"Mon Jan _2 15:04:05 2006",
+}
+
+// ParseDate parses an HTTP date in any of the three formats.
+func ParseDate(s string) (time.Time, error) {
+	for _, layout := range dateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+// FormatDate formats t as an IMF-fixdate.
+func FormatDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// Date parses a date field such as Date, Last-Modified or If-Modified-Since.
+func (h *Headers) Date(name string) (time.Time, error) {
+	return ParseDate(h.Get(name))
+}
+
+func (h *Headers) SetDate(name string, t time.Time) {
+	h.Set(name, FormatDate(t))
+}
+
+// List parses every line of a list-based field as one list.
+func (h *Headers) List(name string) ([]string, error) {
+	return ParseList(h.Combined(name))
+}
+
+func (h *Headers) SetList(name string, elems []string) {
+	h.Set(name, FormatList(elems))
+}
+
+// Weighted is an element of a list weighted by quality values, such as
+// Accept or Accept-Encoding.
+type Weighted struct {
+	// Value is the element without its q parameter, as in "gzip" or
+	// "text/html;level=1".
+	Value string
+	// Q is the weight between 0 and 1; 0 means not acceptable.
+	Q float64
+}
+
+// ParseWeightedList parses a list with quality values, RFC 9110 section
+// 12.4.2. Elements without a q parameter weigh 1. The result is ordered by
+// weight, keeping the order of the list among equal weights.
+func ParseWeightedList(s string) ([]Weighted, error) {
+	elems, err := ParseList(s)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Weighted, 0, len(elems))
+	for _, elem := range elems {
+		parts, err := split(elem, ';')
+		if err != nil {
+			return nil, err
+		}
+		w := Weighted{Value: parts[0], Q: 1}
+		for _, p := range parts[1:] {
+			name, value, _ := strings.Cut(p, "=")
+			if !strings.EqualFold(strings.TrimRight(name, " \t"), "q") {
+				w.Value += ";" + p
+				continue
+			}
+			if w.Q, err = parseQValue(strings.TrimLeft(value, " \t")); err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, w)
+	}
+
+	slices.SortStableFunc(list, func(a, b Weighted) int {
+		switch {
+		case a.Q > b.Q:
+			return -1
+		case a.Q < b.Q:
+			return 1
+		}
+		return 0
+	})
+	return list, nil
+}
+
+// parseQValue parses a qvalue: 0 or 1 with at most three decimals.
+func parseQValue(s string) (float64, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole != "0" && whole != "1" || len(frac) > 3 {
+		return 0, ErrInvalidQValue
+	}
+	for i := 0; i < len(frac); i++ {
+		if frac[i] < '0' || frac[i] > '9' || whole == "1" && frac[i] != '0' {
+			return 0, ErrInvalidQValue
+		}
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// FormatWeightedList writes a list with quality values, leaving out q=1.
+func FormatWeightedList(list []Weighted) string {
+	elems := make([]string, len(list))
+	for i, w := range list {
+		elems[i] = w.Value
+		if w.Q < 1 {
+			q := strconv.FormatFloat(max(w.Q, 0), 'f', 3, 64)
+			elems[i] += ";q=" + strings.TrimRight(strings.TrimRight(q, "0"), ".")
+		}
+	}
+	return FormatList(elems)
+}
+
+// WeightedList parses every line of a field such as Accept as one list
+// with quality values.
+func (h *Headers) WeightedList(name string) ([]Weighted, error) {
+	return ParseWeightedList(h.Combined(name))
+}
+
+func (h *Headers) SetWeightedList(name string, list []Weighted) {
+	h.Set(name, FormatWeightedList(list))
+}
@@ -0,0 +1,133 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaType(t *testing.T) {
+	// Test: Parameters, quoted and not
+	m, err := ParseMediaType(`Multipart/Form-Data; Boundary="----a;b \"c\""; charset=UTF-8`)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", m.Type)
+	assert.Equal(t, map[string]string{"boundary": `----a;b "c"`, "charset": "UTF-8"}, m.Params)
+
+	// Test: Written back with sorted parameters, quoted where needed
+	assert.Equal(t, `multipart/form-data;boundary="----a;b \"c\"";charset=UTF-8`, m.String())
+	assert.Equal(t, "text/plain", MediaType{Type: "text/plain"}.String())
+
+	// Test: Invalid media types
+	for _, s := range []string{"", "text", "text/", "/html", "te xt/html", "text/html; charset", "text/html; charset=\"utf-8", "text/html; charset=a b"} {
+		_, err := ParseMediaType(s)
+		require.ErrorIs(t, err, ErrInvalidMediaType, s)
+	}
+
+	// Test: Headers accessors
+	h := NewHeaders()
+	h.SetContentType(MediaType{Type: "text/html", Params: map[string]string{"charset": "utf-8"}})
+	assert.Equal(t, "text/html;charset=utf-8", h.Get("Content-Type"))
+	m, err = h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "utf-8", m.Params["charset"])
+}
+
+func TestDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: The three formats of RFC 9110
+	for _, s := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		d, err := ParseDate(s)
+		require.NoError(t, err, s)
+		assert.True(t, want.Equal(d), s)
+	}
+
+	// Test: Invalid dates
+	for _, s := range []string{"", "yesterday", "Sun, 06 Nov 1994 08:49:37 PST", "1994-11-06T08:49:37Z"} {
+		_, err := ParseDate(s)
+		require.ErrorIs(t, err, ErrInvalidDate, s)
+	}
+
+	// Test: Written as IMF-fixdate in GMT
+	local := want.In(time.FixedZone("UTC+2", 2*60*60))
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatDate(local))
+
+	h := NewHeaders()
+	h.SetDate("Last-Modified", local)
+	d, err := h.Date("last-modified")
+	require.NoError(t, err)
+	assert.True(t, want.Equal(d))
+}
+
+func TestList(t *testing.T) {
+	// Test: Commas inside quoted-strings, empty elements
+	list, err := ParseList(` W/"a,b" , "c\"d",, e ,`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`W/"a,b"`, `"c\"d"`, "e"}, list)
+
+	// Test: Unterminated quoted-string
+	_, err = ParseList(`"a, b`)
+	require.ErrorIs(t, err, ErrInvalidList)
+
+	// Test: Lines of a field parsed as one list
+	h := NewHeaders()
+	h.Add("Cache-Control", "no-cache, max-age=0")
+	h.Add("cache-control", `private="Set-Cookie, X-Id"`)
+	list, err = h.List("Cache-Control")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"no-cache", "max-age=0", `private="Set-Cookie, X-Id"`}, list)
+
+	h.SetList("Vary", []string{"Accept", "Accept-Encoding"})
+	assert.Equal(t, "Accept, Accept-Encoding", h.Get("vary"))
+
+	// Test: Quoting
+	assert.Equal(t, "token", Quote("token"))
+	assert.Equal(t, `"a \"b\" \\c"`, Quote(`a "b" \c`))
+	assert.Equal(t, `""`, Quote(""))
+	s, err := Unquote(`"a \"b\" \\c"`)
+	require.NoError(t, err)
+	assert.Equal(t, `a "b" \c`, s)
+	for _, bad := range []string{`"`, `"a`, `"a\"`, `"a"b"`} {
+		_, err := Unquote(bad)
+		require.ErrorIs(t, err, ErrInvalidList, bad)
+	}
+}
+
+func TestWeightedList(t *testing.T) {
+	// Test: Ordered by weight, stable among equal weights
+	list, err := ParseWeightedList("text/*;q=0.3, text/html;q=0.7, text/html;level=1, text/html;level=2;q=0.4, */*;q=0.5, application/json")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{
+		{"text/html;level=1", 1},
+		{"application/json", 1},
+		{"text/html", 0.7},
+		{"*/*", 0.5},
+		{"text/html;level=2", 0.4},
+		{"text/*", 0.3},
+	}, list)
+
+	// Test: q=0 and spacing
+	list, err = ParseWeightedList("gzip ; Q=1.000, identity; q=0, br;q=0.")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{{"gzip", 1}, {"identity", 0}, {"br", 0}}, list)
+
+	// Test: Invalid quality values
+	for _, s := range []string{"gzip;q=2", "gzip;q=1.5", "gzip;q=0.1234", "gzip;q=-0", "gzip;q=.5", "gzip;q=", "gzip;q=0.5x"} {
+		_, err := ParseWeightedList(s)
+		require.ErrorIs(t, err, ErrInvalidQValue, s)
+	}
+
+	// Test: Written back without q=1
+	h := NewHeaders()
+	h.SetWeightedList("Accept-Encoding", []Weighted{{"br", 1}, {"gzip", 0.8}, {"deflate", 0.25}, {"identity", 0}})
+	assert.Equal(t, "br, gzip;q=0.8, deflate;q=0.25, identity;q=0", h.Get("accept-encoding"))
+	list, err = h.WeightedList("Accept-Encoding")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{{"br", 1}, {"gzip", 0.8}, {"deflate", 0.25}, {"identity", 0}}, list)
+}
@@ -0,0 +1,167 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxDrainBytes is how much of an unread body is discarded to keep the
+// connection usable for the next request.
+const maxDrainBytes = 256 << 10
+
+// maxChunkLineBytes limits a chunk-size line including its extensions.
+const maxChunkLineBytes = 4 << 10
+
+var (
+	ErrBodyClosed     = errors.New("read on closed body")
+	ErrBodyNotDrained = errors.New("too much of the body left unread")
+)
+
+// NoBody is the Body of requests without one.
+var NoBody = noBody{}
+
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error             { return nil }
+
+// body reads the body of a request through the Reader it came from.
+type body struct {
+	reader  *Reader
+	request *Request
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+	// decoded counts the body bytes, trailerBytes and trailerFields the
+	// trailer section.
+	decoded       int64
+	trailerBytes  int
+	trailerFields int
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+	return b.read(p)
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	r := b.request
+	for len(r.pending) == 0 {
+		if r.done() {
+			b.err = io.EOF
+			return 0, io.EOF
+		}
+
+		state := r.state
+		readN, err := r.parse(b.reader.buf.Bytes())
+		if err == nil {
+			err = b.checkLimits(state, readN)
+		}
+		if err != nil {
+			b.err = r.fail(err)
+			return 0, b.err
+		}
+		if readN > 0 {
+			b.reader.buf.Next(readN)
+			continue
+		}
+
+		if _, err := b.reader.fill(); err != nil {
+			b.err = r.fail(err)
+			return 0, b.err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// checkLimits checks the reader limits after a parse step in state that
+// consumed n bytes, or none if it needs more data.
+func (b *body) checkLimits(state parserState, n int) error {
+	r := b.request
+	limits := b.reader
+	buffered := limits.buf.Len()
+
+	switch state {
+	case StateBody, StateChunkData:
+		b.decoded += int64(n)
+	case StateChunkSize:
+		if n == 0 && buffered >= maxChunkLineBytes {
+			return ErrMalformedChunk
+		}
+		// Refuse a chunk as soon as its size is known to exceed the limit.
+		if r.state == StateChunkData && limits.MaxBodyBytes > 0 && r.chunkRemaining > uint64(limits.MaxBodyBytes-b.decoded) {
+			return ErrBodyTooLarge
+		}
+	case StateTrailers:
+		b.trailerBytes += n
+		if n > 0 && r.state == StateTrailers {
+			b.trailerFields++
+		}
+		total := b.trailerBytes
+		if n == 0 {
+			total += buffered + 1
+		}
+		if limits.MaxHeaderBytes > 0 && total > limits.MaxHeaderBytes {
+			return ErrHeaderTooLarge
+		}
+		if limits.MaxHeaderFields > 0 && b.trailerFields > limits.MaxHeaderFields {
+			return ErrTooManyHeaders
+		}
+	}
+
+	if limits.MaxBodyBytes > 0 && b.decoded > limits.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+	return nil
+}
+
+// Close discards the rest of the body so that the next request can be
+// read. If more than maxDrainBytes are left it gives up and returns
+// ErrBodyNotDrained; the connection cannot be reused then.
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return b.drain()
+}
+
+// finish drains the body for the next request without closing it.
+func (b *body) finish() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.drain()
+}
+
+func (b *body) drain() error {
+	if b.err == nil {
+		buf := make([]byte, 4096)
+		for drained := 0; b.err == nil && drained < maxDrainBytes; {
+			n, _ := b.read(buf)
+			drained += n
+		}
+		if b.err == nil {
+			b.err = ErrBodyNotDrained
+		}
+	}
+
+	if b.err == io.EOF {
+		return nil
+	}
+	return b.err
+}
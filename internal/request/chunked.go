@@ -0,0 +1,107 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/rizalta/httpone/internal/headers"
+)
+
+var ErrMalformedChunk = errors.New("malformed chunked body")
+
+// parseChunkSize parses a chunk-size line with its optional chunk
+// extensions, which are checked and then ignored. It returns 0 bytes read
+// until the whole line is available.
+func parseChunkSize(data []byte) (uint64, int, error) {
+	idx := bytes.Index(data, crlf)
+	if idx == -1 {
+		return 0, 0, nil
+	}
+
+	line := data[:idx]
+	// A bare CR or LF ends the line for some recipients but not others, so
+	// no control character other than HTAB may appear in it.
+	if !headers.ValidFieldValue(string(line)) {
+		return 0, 0, ErrMalformedChunk
+	}
+	sizeStr, exts, _ := bytes.Cut(line, []byte(";"))
+	sizeStr = bytes.TrimRight(sizeStr, " \t")
+	if len(sizeStr) == 0 || len(sizeStr) > 16 {
+		return 0, 0, ErrMalformedChunk
+	}
+	size, err := strconv.ParseUint(string(sizeStr), 16, 64)
+	if err != nil {
+		return 0, 0, ErrMalformedChunk
+	}
+
+	if len(line) > len(sizeStr) && !validChunkExtensions(exts) {
+		return 0, 0, ErrMalformedChunk
+	}
+
+	return size, idx + len(crlf), nil
+}
+
+// validChunkExtensions checks a list of name[=value] pairs separated by
+// ';', where value is a token or a quoted-string.
+func validChunkExtensions(exts []byte) bool {
+	for len(exts) > 0 {
+		var ext []byte
+		ext, exts = splitChunkExtension(exts)
+		if ext == nil {
+			return false
+		}
+		name, value, hasValue := bytes.Cut(ext, []byte("="))
+		if !headers.IsToken(string(bytes.Trim(name, " \t"))) {
+			return false
+		}
+		if !hasValue {
+			continue
+		}
+		value = bytes.Trim(value, " \t")
+		if !headers.IsToken(string(value)) && !isQuotedString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitChunkExtension returns the first extension and the rest after its
+// ';'. Semicolons inside quoted-strings do not split.
+func splitChunkExtension(exts []byte) ([]byte, []byte) {
+	quoted := false
+	for i := 0; i < len(exts); i++ {
+		switch {
+		case quoted && exts[i] == '\\':
+			i++
+		case exts[i] == '"':
+			quoted = !quoted
+		case !quoted && exts[i] == ';':
+			return exts[:i], exts[i+1:]
+		}
+	}
+	if quoted {
+		return nil, nil
+	}
+	return exts, nil
+}
+
+func isQuotedString(b []byte) bool {
+	if len(b) < 2 || b[0] != '"' || b[len(b)-1] != '"' {
+		return false
+	}
+	for i := 1; i < len(b)-1; i++ {
+		switch c := b[i]; {
+		case c == '\\':
+			i++
+			if i == len(b)-1 {
+				return false
+			}
+		case c == '"':
+			return false
+		case c < ' ' && c != '\t', c == 0x7f:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package request
+
+import (
+	"errors"
+	"io"
+
+	"github.com/rizalta/httpone/internal/headers"
+	"github.com/rizalta/httpone/internal/response"
+)
+
+// Error is a request that could not be read, with the status code it
+// should be answered with.
+type Error struct {
+	StatusCode response.StatusCode
+	// HTTPVersion is the version from the request-line, or empty if the
+	// error came before it was read.
+	HTTPVersion string
+	Err         error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// HTTP10 reports whether the request was an HTTP/1.0 one, which has to be
+// answered without chunked transfer-coding.
+func (e *Error) HTTP10() bool {
+	return e.HTTPVersion == "1.0"
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var errorStatus = []struct {
+	err        error
+	statusCode response.StatusCode
+}{
+	{ErrMalformedRequestLine, response.StatusBadRequest},
+	{ErrInvalidMethod, response.StatusBadRequest},
+	{ErrInvalidTarget, response.StatusBadRequest},
+	{ErrTargetForm, response.StatusBadRequest},
+	{ErrMissingHost, response.StatusBadRequest},
+	{ErrInvalidHost, response.StatusBadRequest},
+	{headers.ErrMalformedHeader, response.StatusBadRequest},
+	{headers.ErrInvalidHeaderName, response.StatusBadRequest},
+	{headers.ErrInvalidHeaderValue, response.StatusBadRequest},
+	{headers.ErrDuplicateHeader, response.StatusBadRequest},
+	{ErrMalformedChunk, response.StatusBadRequest},
+	{ErrInvalidContentLength, response.StatusBadRequest},
+	{ErrAmbiguousLength, response.StatusBadRequest},
+	{ErrInvalidTransferEncoding, response.StatusBadRequest},
+	{ErrObsFold, response.StatusBadRequest},
+	{ErrTransferEncodingHTTP10, response.StatusBadRequest},
+	{io.ErrUnexpectedEOF, response.StatusBadRequest},
+	{ErrUnsupportedTransferCoding, response.StatusNotImplemented},
+	{ErrUnsupportedHTTPVersion, response.StatusHTTPVersionNotSupported},
+	{ErrURITooLong, response.StatusURITooLong},
+	{ErrHeaderTooLarge, response.StatusRequestHeaderFieldsTooLarge},
+	{ErrTooManyHeaders, response.StatusRequestHeaderFieldsTooLarge},
+	{ErrBodyTooLarge, response.StatusRequestEntityTooLarge},
+	{ErrExpectationFailed, response.StatusExpectationFailed},
+}
+
+// newError gives err the status code it should be answered with. Errors
+// from the underlying reader other than timeouts are returned as they are.
+func newError(err error) error {
+	var reqErr *Error
+	if errors.As(err, &reqErr) {
+		return err
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return &Error{StatusCode: response.StatusRequestTimeout, Err: err}
+	}
+
+	for _, es := range errorStatus {
+		if errors.Is(err, es.err) {
+			return &Error{StatusCode: es.statusCode, Err: err}
+		}
+	}
+	return err
+}
+
+// fail is newError for an error in r, recording the version of its
+// request-line once that has been read.
+func (r *Request) fail(err error) error {
+	err = newError(err)
+	var reqErr *Error
+	if errors.As(err, &reqErr) && reqErr.HTTPVersion == "" {
+		reqErr.HTTPVersion = r.RequestLine.HTTPVersion
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package request
+
+import (
+	"sync"
+
+	"github.com/rizalta/httpone/internal/headers"
+)
+
+var (
+	methodsMu sync.RWMutex
+	methods   = map[string]struct{}{
+		"GET":     {},
+		"HEAD":    {},
+		"POST":    {},
+		"PUT":     {},
+		"DELETE":  {},
+		"CONNECT": {},
+		"OPTIONS": {},
+		"TRACE":   {},
+		"PATCH":   {},
+	}
+)
+
+// RegisterMethod adds an extension method, such as PROPFIND or PURGE, to
+// the methods known to KnownMethod. Any token is read as a method whether
+// it is registered or not. It panics if method is not a token.
+func RegisterMethod(method string) {
+	if !headers.IsToken(method) {
+		panic("request: invalid method " + method)
+	}
+	methodsMu.Lock()
+	defer methodsMu.Unlock()
+	methods[method] = struct{}{}
+}
+
+// KnownMethod reports whether method is a standard method or one added with
+// RegisterMethod. Methods are case-sensitive.
+func KnownMethod(method string) bool {
+	methodsMu.RLock()
+	defer methodsMu.RUnlock()
+	_, ok := methods[method]
+	return ok
+}
@@ -3,9 +3,14 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"io"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/rizalta/httpone/internal/headers"
 )
@@ -19,40 +24,209 @@ type RequestLine struct {
 type parserState string
 
 const (
-	StateInit    parserState = "init"
-	StateHeaders parserState = "headers"
-	StateBody    parserState = "body"
-	StateDone    parserState = "done"
+	StateInit      parserState = "init"
+	StateHeaders   parserState = "headers"
+	StateBody      parserState = "body"
+	StateChunkSize parserState = "chunk size"
+	StateChunkData parserState = "chunk data"
+	StateChunkEnd  parserState = "chunk end"
+	StateTrailers  parserState = "trailers"
+	StateDone      parserState = "done"
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       parserState
+	// URL is parsed from the request-target. Its Path is decoded, while
+	// EscapedPath returns the path as sent and Query the query parameters,
+	// keeping every value of a repeated key.
+	URL *url.URL
+	// TargetForm is the form the request-target was sent in.
+	TargetForm TargetForm
+	// Host is the host the request is for, taken from the request-target
+	// when it has an authority and from the Host header otherwise.
+	Host    string
+	Headers *headers.Headers
+	// Body reads the body from the connection as it is consumed. It is
+	// NoBody for requests without one.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body, once the body
+	// has been read to the end.
+	Trailers *headers.Headers
+
+	// TLS holds the negotiated state of a TLS connection, including the
+	// version, cipher suite and peer certificates. It is nil on plain
+	// connections.
+	TLS *tls.ConnectionState
+
+	ctx            context.Context
+	state          parserState
+	bodyRemaining  int64
+	chunkRemaining uint64
+	// pending holds body bytes parsed but not yet read.
+	pending []byte
+}
+
+// Context returns the context of the request. For server requests it is
+// canceled when the connection closes, when the server starts shutting
+// down, or when the handler timeout passes.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// VerifiedChain returns the verified chain of the client certificate,
+// leaf first, or nil if the client did not present one.
+func (r *Request) VerifiedChain() []*x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0]
+}
+
+// ClientSubject returns the subject of the verified client certificate.
+func (r *Request) ClientSubject() string {
+	chain := r.VerifiedChain()
+	if len(chain) == 0 {
+		return ""
+	}
+	return chain[0].Subject.String()
 }
 
 func (r *Request) done() bool {
 	return r.state == StateDone
 }
 
-func (r *Request) contentLength() int {
-	contentLenStr := r.Headers.Get("content-length")
-	if contentLenStr == "" {
-		return 0
+// contentLength parses the content-length field, which must be a plain
+// decimal number. Lists of values are refused, even when they agree.
+func (r *Request) contentLength() (int64, error) {
+	value := r.Headers.Get("content-length")
+	if value == "" || len(value) > 18 {
+		return 0, ErrInvalidContentLength
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, ErrInvalidContentLength
+		}
 	}
+	return strconv.ParseInt(value, 10, 64)
+}
 
-	contentLen, err := strconv.Atoi(contentLenStr)
-	if err != nil {
-		return 0
+// KeepAlive reports whether the client allows the connection to be reused
+// after this request. HTTP/1.0 clients have to ask for it with
+// Connection: keep-alive.
+func (r *Request) KeepAlive() bool {
+	keepAlive := !r.HTTP10()
+	for _, option := range strings.Split(r.Headers.Combined("connection"), ",") {
+		option = strings.TrimSpace(option)
+		if strings.EqualFold(option, "close") {
+			return false
+		}
+		if strings.EqualFold(option, "keep-alive") {
+			keepAlive = true
+		}
+	}
+	return keepAlive
+}
+
+// ExpectContinue reports whether the client waits for a 100 Continue
+// response before sending the body. HTTP/1.0 clients cannot ask for one.
+func (r *Request) ExpectContinue() bool {
+	return !r.HTTP10() && strings.EqualFold(r.Headers.Combined("expect"), "100-continue")
+}
+
+// checkExpect refuses expectations other than 100-continue, which is the
+// only one defined.
+func (r *Request) checkExpect() error {
+	if !r.Headers.Has("expect") || r.HTTP10() || strings.EqualFold(r.Headers.Combined("expect"), "100-continue") {
+		return nil
+	}
+	return ErrExpectationFailed
+}
+
+// HTTP10 reports whether the request was sent with HTTP/1.0.
+func (r *Request) HTTP10() bool {
+	return r.RequestLine.HTTPVersion == "1.0"
+}
+
+// checkTransferEncoding checks that chunked is the final transfer-coding
+// and the only one applied, as no other coding is supported.
+func (r *Request) checkTransferEncoding() error {
+	codings := strings.Split(r.Headers.Combined("transfer-encoding"), ",")
+	for i, coding := range codings {
+		coding = strings.TrimSpace(coding)
+		switch {
+		case i == len(codings)-1:
+			if !strings.EqualFold(coding, "chunked") {
+				return ErrInvalidTransferEncoding
+			}
+		case strings.EqualFold(coding, "chunked"):
+			return ErrInvalidTransferEncoding
+		case coding != "":
+			return ErrUnsupportedTransferCoding
+		}
 	}
-	return max(0, contentLen)
+	return nil
+}
+
+// setFraming decides how the body is delimited once the header is read.
+// Any doubt about where the body ends is an error, so that the request
+// cannot be read differently by a proxy in front of the server.
+func (r *Request) setFraming() error {
+	hasTE := r.Headers.Has("transfer-encoding")
+	hasCL := r.Headers.Has("content-length")
+
+	switch {
+	case hasTE && hasCL:
+		return ErrAmbiguousLength
+	case hasTE && r.HTTP10():
+		// Chunked transfer-coding came with HTTP/1.1, so an HTTP/1.0
+		// message cannot use it and is likely smuggled.
+		return ErrTransferEncodingHTTP10
+	case hasTE:
+		if err := r.checkTransferEncoding(); err != nil {
+			return err
+		}
+		r.state = StateChunkSize
+	case hasCL:
+		length, err := r.contentLength()
+		if err != nil {
+			return err
+		}
+		r.bodyRemaining = length
+		r.state = StateBody
+		if length == 0 {
+			r.state = StateDone
+		}
+	default:
+		r.state = StateDone
+	}
+	return nil
+}
+
+// isObsFold reports whether data starts with whitespace, which would make
+// the line a continuation of the previous field. Line folding is obsolete
+// and not understood the same way by every recipient.
+func isObsFold(data []byte) bool {
+	return len(data) > 0 && (data[0] == ' ' || data[0] == '\t')
 }
 
 func newRequest() *Request {
 	return &Request{
-		state:   StateInit,
-		Headers: headers.NewHeaders(),
+		state:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
 	}
 }
 
@@ -70,69 +244,124 @@ func (r *Request) parse(data []byte) (int, error) {
 			return 0, nil
 		}
 
+		// Kept before the target is checked, so that an error can still be
+		// answered in the version of the request.
 		r.RequestLine = *rl
+		form, u, err := parseTarget(rl.Method, rl.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
+
+		r.TargetForm = form
+		r.URL = u
 		r.state = StateHeaders
 
 		return n, nil
 
 	case StateHeaders:
+		if isObsFold(data) {
+			return 0, ErrObsFold
+		}
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
-			if r.contentLength() > 0 {
-				r.state = StateBody
-			} else {
-				r.state = StateDone
+			if err := r.setHost(); err != nil {
+				return 0, err
+			}
+			if err := r.checkExpect(); err != nil {
+				return 0, err
+			}
+			if err := r.setFraming(); err != nil {
+				return 0, err
 			}
 		}
 		return n, nil
 
 	case StateBody:
-		length := r.contentLength()
-		if length == 0 {
+		read := int(min(r.bodyRemaining, int64(len(data))))
+		r.pending = append(r.pending, data[:read]...)
+		r.bodyRemaining -= int64(read)
+		if r.bodyRemaining == 0 {
 			r.state = StateDone
-			return 0, nil
 		}
 
-		needed := length - len(r.Body)
-		available := len(data)
-		read := min(needed, available)
+		return read, nil
 
-		r.Body = append(r.Body, data[:read]...)
-		if len(r.Body) == length {
-			r.state = StateDone
+	case StateChunkSize:
+		size, n, err := parseChunkSize(data)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
 		}
 
+		if size == 0 {
+			r.state = StateTrailers
+		} else {
+			r.chunkRemaining = size
+			r.state = StateChunkData
+		}
+		return n, nil
+
+	case StateChunkData:
+		read := int(min(r.chunkRemaining, uint64(len(data))))
+		r.pending = append(r.pending, data[:read]...)
+		r.chunkRemaining -= uint64(read)
+		if r.chunkRemaining == 0 {
+			r.state = StateChunkEnd
+		}
 		return read, nil
+
+	case StateChunkEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, crlf) {
+			return 0, ErrMalformedChunk
+		}
+		r.state = StateChunkSize
+		return len(crlf), nil
+
+	case StateTrailers:
+		if isObsFold(data) {
+			return 0, ErrObsFold
+		}
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = StateDone
+		}
+		return n, nil
 	}
 
 	return 0, nil
 }
 
 var crlf = []byte("\r\n")
+
 var (
 	ErrMalformedRequestLine   = errors.New("malformed request-line")
 	ErrUnsupportedHTTPVersion = errors.New("unsupported http version")
 	ErrInvalidMethod          = errors.New("invalid method")
+	ErrHeaderTooLarge         = errors.New("request header too large")
+	ErrURITooLong             = errors.New("request-line too long")
+	ErrTooManyHeaders         = errors.New("too many header fields")
+	ErrBodyTooLarge           = errors.New("request body too large")
+
+	ErrInvalidContentLength      = errors.New("invalid content-length")
+	ErrAmbiguousLength           = errors.New("both content-length and transfer-encoding")
+	ErrInvalidTransferEncoding   = errors.New("transfer-encoding does not end in chunked")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer-coding")
+	ErrObsFold                   = errors.New("obsolete line folding")
+	ErrTransferEncodingHTTP10    = errors.New("transfer-encoding in http/1.0 request")
+	ErrExpectationFailed         = errors.New("unsupported expectation")
 )
 
-var methods = map[string]struct{}{
-	"GET":     {},
-	"POST":    {},
-	"PUT":     {},
-	"DELETE":  {},
-	"HEAD":    {},
-	"OPTIONS": {},
-	"PATCH":   {},
-}
-
-func isValidMethod(m string) bool {
-	_, ok := methods[m]
-	return ok
-}
-
 func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(b, crlf)
 	if idx == -1 {
@@ -142,12 +371,16 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	requestLine := b[:idx]
 	read := len(requestLine) + len(crlf)
 
+	if bytes.ContainsAny(requestLine, "\r\n") {
+		return nil, 0, ErrMalformedRequestLine
+	}
+
 	parts := bytes.Split(requestLine, []byte(" "))
 	if len(parts) != 3 {
 		return nil, 0, ErrMalformedRequestLine
 	}
 
-	if !isValidMethod(string(parts[0])) {
+	if !headers.IsToken(string(parts[0])) {
 		return nil, 0, ErrInvalidMethod
 	}
 
@@ -155,7 +388,7 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	if len(httpParts) != 2 || string(httpParts[0]) != "HTTP" {
 		return nil, 0, ErrMalformedRequestLine
 	}
-	if string(httpParts[1]) != "1.1" {
+	if version := string(httpParts[1]); version != "1.1" && version != "1.0" {
 		return nil, 0, ErrUnsupportedHTTPVersion
 	}
 
@@ -168,47 +401,161 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	return rl, read, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
+// Reader reads requests one after another. Its limits are checked before
+// more data is buffered; zero means no limit.
+type Reader struct {
+	// MaxRequestLineBytes limits the request-line.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes limits the request-line and header fields together,
+	// and separately the trailer fields of a chunked body.
+	MaxHeaderBytes int
+	// MaxHeaderFields limits the number of header fields, and separately
+	// the number of trailer fields.
+	MaxHeaderFields int
+	// MaxBodyBytes limits the size of the decoded body.
+	MaxBodyBytes int64
+
+	reader io.Reader
+	buf    *bytes.Buffer
+	body   *body
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buf:    &bytes.Buffer{},
+	}
+}
 
-	buf := &bytes.Buffer{}
+// Buffered returns the number of bytes already read past the end of the
+// last request. They are the start of the next one.
+func (r *Reader) Buffered() int {
+	return r.buf.Len()
+}
 
-	for !request.done() {
-		data := buf.Bytes()
-		readN, err := request.parse(data)
-		if err != nil {
+// ReadRequest reads the request-line and header fields of the next
+// request. Its body is read from the same reader through Request.Body, and
+// is drained here if the caller did not. Bytes read past the end of the
+// request are kept for the following call. It returns io.EOF if the reader
+// ends before any byte of a new request arrives.
+func (r *Reader) ReadRequest() (*Request, error) {
+	if r.body != nil {
+		if err := r.body.finish(); err != nil {
 			return nil, err
 		}
+		r.body = nil
+	}
+
+	request := newRequest()
+	started := r.buf.Len() > 0
+	consumed := 0
+	fields := 0
+
+	for request.state == StateInit || request.state == StateHeaders {
+		state := request.state
+		readN, err := request.parse(r.buf.Bytes())
+		if err != nil {
+			return nil, request.fail(err)
+		}
 
 		if readN > 0 {
-			buf.Next(readN)
+			r.buf.Next(readN)
+			consumed += readN
+			if err := r.checkHeader(state, consumed, 0); err != nil {
+				return nil, request.fail(err)
+			}
+			if state == StateHeaders && request.state == StateHeaders {
+				fields++
+				if r.MaxHeaderFields > 0 && fields > r.MaxHeaderFields {
+					return nil, request.fail(ErrTooManyHeaders)
+				}
+			}
 			continue
 		}
 
-		chunk := make([]byte, 1024)
-		n, err := reader.Read(chunk)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return nil, err
+		// Stop before buffering more than the limits allow.
+		if err := r.checkHeader(state, consumed, r.buf.Len()); err != nil {
+			return nil, request.fail(err)
 		}
 
+		n, err := r.fill()
 		if n > 0 {
-			buf.Write(chunk[:n])
+			started = true
 		}
-
-		if errors.Is(err, io.EOF) && n == 0 {
-			if buf.Len() > 0 {
-				_, parseErr := request.parse(buf.Bytes())
-				if parseErr != nil {
-					return nil, parseErr
-				}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) && !started {
+				return nil, io.EOF
 			}
+			return nil, request.fail(err)
+		}
+	}
 
-			if !request.done() {
-				return nil, io.ErrUnexpectedEOF
-			}
-			break
+	if r.MaxBodyBytes > 0 && request.bodyRemaining > r.MaxBodyBytes {
+		return nil, request.fail(ErrBodyTooLarge)
+	}
+
+	if request.done() {
+		request.Body = NoBody
+	} else {
+		r.body = &body{reader: r, request: request}
+		request.Body = r.body
+	}
+	return request, nil
+}
+
+// checkHeader checks the header limits for consumed bytes already parsed
+// plus buffered bytes still waiting for the end of their line.
+func (r *Reader) checkHeader(state parserState, consumed, buffered int) error {
+	total := consumed + buffered
+	if buffered > 0 {
+		// The line is still incomplete, so reaching the limit exceeds it.
+		total++
+	}
+	if state == StateInit && r.MaxRequestLineBytes > 0 && total > r.MaxRequestLineBytes {
+		return ErrURITooLong
+	}
+	if r.MaxHeaderBytes > 0 && total > r.MaxHeaderBytes {
+		return ErrHeaderTooLarge
+	}
+	return nil
+}
+
+// fill reads more data into the buffer. Running out of data is only an
+// error once nothing more could be read.
+func (r *Reader) fill() (int, error) {
+	chunk := make([]byte, 1024)
+	n, err := r.reader.Read(chunk)
+	if n > 0 {
+		r.buf.Write(chunk[:n])
+	}
+
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
 		}
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// RequestFromReader reads a single request including its whole body, which
+// is kept in memory. It suits small bodies; use a Reader to stream them.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	request, err := NewReader(reader).ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	if request.Body == NoBody {
+		return request, nil
 	}
 
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(data))
 	return request, nil
 }
@@ -0,0 +1,204 @@
+package request
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// TargetForm is the form of a request-target, see RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, "/where?q=now".
+	OriginForm TargetForm = iota
+	// AbsoluteForm is an absolute URI, "http://www.example.org/pub", as
+	// sent to proxies.
+	AbsoluteForm
+	// AuthorityForm is a host and port, "www.example.com:80", only used
+	// with CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*", only used with a server-wide OPTIONS.
+	AsteriskForm
+)
+
+var (
+	ErrInvalidTarget = errors.New("invalid request-target")
+	ErrTargetForm    = errors.New("request-target form not allowed for method")
+	ErrMissingHost   = errors.New("missing host")
+	ErrInvalidHost   = errors.New("invalid host")
+)
+
+func targetForm(target string) TargetForm {
+	switch {
+	case target == "*":
+		return AsteriskForm
+	case strings.HasPrefix(target, "/"):
+		return OriginForm
+	case strings.Contains(target, "://"):
+		return AbsoluteForm
+	default:
+		return AuthorityForm
+	}
+}
+
+// checkTargetForm checks that the form of the request-target can be used
+// with method: CONNECT takes the authority-form and nothing else takes it,
+// and the asterisk-form is only for OPTIONS.
+func checkTargetForm(method string, form TargetForm) error {
+	if (method == "CONNECT") != (form == AuthorityForm) {
+		return ErrTargetForm
+	}
+	if form == AsteriskForm && method != "OPTIONS" {
+		return ErrTargetForm
+	}
+	return nil
+}
+
+// parseTarget parses the request-target into a URL after checking its form
+// against method. Only characters allowed in a URI are accepted and every
+// '%' must start a valid escape. The query is left to the handler, so a
+// target using ';' in it is accepted.
+func parseTarget(method, target string) (TargetForm, *url.URL, error) {
+	form := targetForm(target)
+	if err := checkTargetForm(method, form); err != nil {
+		return form, nil, err
+	}
+
+	switch form {
+	case AsteriskForm:
+		return form, &url.URL{Path: "*"}, nil
+	case AuthorityForm:
+		// The port is required.
+		if _, port, err := net.SplitHostPort(target); err != nil || port == "" || !validHost(target) {
+			return form, nil, ErrInvalidTarget
+		}
+		return form, &url.URL{Host: target}, nil
+	}
+
+	if !validTarget(target) {
+		return form, nil, ErrInvalidTarget
+	}
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return form, nil, ErrInvalidTarget
+	}
+	if form == AbsoluteForm {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return form, nil, ErrInvalidTarget
+		}
+		// Userinfo is deprecated in http URIs and a known phishing vector.
+		if u.User != nil || u.Host == "" || !validHost(u.Host) {
+			return form, nil, ErrInvalidTarget
+		}
+	}
+	return form, u, nil
+}
+
+// validTarget checks target against the characters of RFC 3986, leaving
+// out '#' since a request-target carries no fragment.
+func validTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2]) {
+				return false
+			}
+			i += 2
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		default:
+			switch c {
+			case '-', '.', '_', '~', '!', '$', '&', '\'', '(', ')', '*', '+', ',', ';', '=', ':', '@', '/', '?':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validHost checks a uri-host with an optional port, as found in the Host
+// header and in the authority of a request-target.
+func validHost(host string) bool {
+	name, port := host, ""
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 {
+			return false
+		}
+		name, port = host[1:end], host[end+1:]
+		if ip := net.ParseIP(name); ip == nil || !strings.Contains(name, ":") {
+			return false
+		}
+	} else {
+		if i := strings.LastIndexByte(host, ':'); i >= 0 {
+			name, port = host[:i], host[i:]
+		}
+		if strings.ContainsAny(name, ":/?@") || !validTarget(name) {
+			return false
+		}
+	}
+
+	if port == "" {
+		return true
+	}
+	if port[0] != ':' {
+		return false
+	}
+	for i := 1; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// setHost checks the Host header and records the host the request is for.
+// The authority of an absolute-form or authority-form target takes the
+// place of the header.
+func (r *Request) setHost() error {
+	if !r.Headers.Has("host") {
+		if r.RequestLine.HTTPVersion == "1.1" {
+			return ErrMissingHost
+		}
+	} else if host := r.Headers.Get("host"); host != "" && !validHost(host) {
+		return ErrInvalidHost
+	}
+
+	switch r.TargetForm {
+	case AbsoluteForm, AuthorityForm:
+		r.Host = r.URL.Host
+	default:
+		r.Host = r.Headers.Get("host")
+	}
+	return nil
+}
+
+// EffectiveURL returns the target URI of the request, rebuilt from the
+// request-target and the Host header as described in RFC 9112 section 3.3.
+// The scheme is https for requests received over TLS.
+func (r *Request) EffectiveURL() *url.URL {
+	u := *r.URL
+	switch r.TargetForm {
+	case AbsoluteForm, AuthorityForm:
+		return &u
+	case AsteriskForm:
+		u.Path = ""
+	}
+
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.Host = r.Host
+	return &u
+}
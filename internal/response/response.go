@@ -2,19 +2,30 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"unicode"
+	"strings"
 
 	"github.com/rizalta/httpone/internal/headers"
 )
 
 type Writer interface {
 	WriteHeader(statusCode StatusCode) error
+	// WriteInformational sends an interim 1xx response with its own
+	// header fields, which may be nil, ahead of the final response.
+	WriteInformational(statusCode StatusCode, h *headers.Headers) error
 	Write([]byte) (int, error)
-	Headers() headers.Headers
+	Headers() *headers.Headers
 }
 
+var (
+	ErrResponseDone     = errors.New("response already completed")
+	ErrHeaderWritten    = errors.New("header already written")
+	ErrNotInformational = errors.New("not an informational status code")
+	ErrSwitchProtocols  = errors.New("switching protocols is not supported")
+)
+
 type writerState int
 
 const (
@@ -26,8 +37,17 @@ const (
 
 type response struct {
 	state   writerState
-	headers headers.Headers
-	writer  io.Writer
+	status  StatusCode
+	chunked bool
+	http10  bool
+	// head is set for a response to HEAD, which has no body.
+	head bool
+	// continued is set once 100 Continue has been sent.
+	continued bool
+	// beforeHeader runs just before the final header is written.
+	beforeHeader func()
+	headers      *headers.Headers
+	writer       io.Writer
 }
 
 func NewResponse(w io.Writer) *response {
@@ -38,67 +58,237 @@ func NewResponse(w io.Writer) *response {
 	}
 }
 
+// NewHTTP10Response returns a response to an HTTP/1.0 request. Its status
+// line carries HTTP/1.0, and a body without a Content-Length is delimited
+// by closing the connection, since chunked transfer-coding is unknown to
+// the client.
+func NewHTTP10Response(w io.Writer) *response {
+	r := NewResponse(w)
+	r.http10 = true
+	return r
+}
+
+// SetRequestMethod tells the response the method of its request. The
+// response to HEAD carries the header fields a GET would, but Write
+// discards the body and sends no chunk framing, since the client reads
+// none.
+func (w *response) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// SetBeforeHeader sets f to run just before the header of the final
+// response is written, whether by WriteHeader, Write or Flush, so that
+// fields that depend on the moment of writing, such as Connection during a
+// shutdown, can still be changed.
+func (w *response) SetBeforeHeader(f func()) {
+	w.beforeHeader = f
+}
+
+// WriteHeader writes the status line and header fields of the final
+// response. A 1xx statusCode sends an interim response without header
+// fields instead, as WriteInformational does. Fields with an invalid name
+// or a value containing CR, LF or NUL are not sent, and the error for the
+// first one is returned. The header is written once; later calls return
+// ErrHeaderWritten.
 func (w *response) WriteHeader(statusCode StatusCode) error {
-	header := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, statusMessage[statusCode])
-	for n := range w.headers {
-		header = fmt.Appendf(header, "%s: %s\r\n", formatHeaderName(n), w.headers.Get(n))
+	if isInformational(statusCode) {
+		return w.WriteInformational(statusCode, nil)
+	}
+	if w.state != stateInit {
+		return ErrHeaderWritten
+	}
+	if w.beforeHeader != nil {
+		w.beforeHeader()
+	}
+
+	proto := "HTTP/1.1"
+	if w.http10 {
+		proto = "HTTP/1.0"
+	}
+	header := fmt.Appendf(nil, "%s %d %s\r\n", proto, statusCode, statusMessage[statusCode])
+	var fieldErr error
+	for _, f := range w.headers.Fields() {
+		if w.http10 && strings.EqualFold(f.Name, "connection") {
+			continue
+		}
+		header, fieldErr = appendField(header, f, fieldErr)
 	}
 	_, err := w.writer.Write(header)
+	w.status = statusCode
 	w.state = stateHeader
-	return err
+	if err != nil {
+		return err
+	}
+	return fieldErr
+}
+
+// WriteInformational sends an interim response, such as 103 Early Hints
+// with Link fields, before the final one. Any number may be sent, each with
+// its own fields in h; the fields of Headers are kept for the final
+// response. 100 Continue is sent at most once, and nothing is sent to
+// HTTP/1.0 clients, which do not understand interim responses. 101 is
+// refused, since the connection cannot be handed over to another protocol.
+// Invalid fields in h are left out as in WriteHeader.
+func (w *response) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if !isInformational(statusCode) {
+		return ErrNotInformational
+	}
+	if statusCode == StatusSwitchingProtocols {
+		return ErrSwitchProtocols
+	}
+	if w.state != stateInit {
+		return ErrHeaderWritten
+	}
+	if w.http10 || statusCode == StatusContinue && w.continued {
+		return nil
+	}
+
+	header := fmt.Appendf(nil, "HTTP/1.1 %d %s\r\n", statusCode, statusMessage[statusCode])
+	var fieldErr error
+	if h != nil {
+		for _, f := range h.Fields() {
+			header, fieldErr = appendField(header, f, fieldErr)
+		}
+	}
+	header = append(header, "\r\n"...)
+	if _, err := w.writer.Write(header); err != nil {
+		return err
+	}
+	if statusCode == StatusContinue {
+		w.continued = true
+	}
+	return fieldErr
+}
+
+// appendField writes a field line with the name in the case it was given.
+// A field that fails headers.CheckField is left out, so that the rest of
+// the response stays intact; the first such error is kept in err.
+func appendField(b []byte, f headers.Field, err error) ([]byte, error) {
+	if fieldErr := headers.CheckField(f); fieldErr != nil {
+		if err == nil {
+			err = fieldErr
+		}
+		return b, err
+	}
+	return fmt.Appendf(b, "%s: %s\r\n", f.Name, f.Value), err
+}
+
+func isInformational(statusCode StatusCode) bool {
+	return statusCode >= 100 && statusCode < 200
 }
 
+// Write sends p as part of the body. Unless the handler set a
+// Content-Length before writing the header, the body is sent with chunked
+// transfer-coding so that the connection can be reused afterwards. HTTP/1.0
+// bodies end with the connection instead, which Connection: close in
+// Headers then reports. If Write writes the header, p is still sent when a
+// field had to be left out, and the error of WriteHeader is returned.
 func (w *response) Write(p []byte) (int, error) {
+	if w.state == stateDone {
+		return 0, ErrResponseDone
+	}
+	var headerErr error
 	if w.state == stateInit {
-		w.WriteHeader(StatusOK)
+		headerErr = w.WriteHeader(StatusOK)
 	}
-	contentLenHeader := fmt.Appendf(nil, "%s: %d\r\n\r\n", "Content-Length", len(p))
-	_, err := w.writer.Write(contentLenHeader)
-	if err != nil {
+	n, err := w.writeBody(p)
+	if err == nil {
+		err = headerErr
+	}
+	return n, err
+}
+
+func (w *response) writeBody(p []byte) (int, error) {
+	if w.state == stateHeader {
+		var header []byte
+		switch {
+		case w.head, w.headers.Get("content-length") != "":
+		case w.http10:
+			w.headers.Set("Connection", "close")
+		default:
+			w.chunked = true
+			header = fmt.Appendf(header, "%s: %s\r\n", "Transfer-Encoding", "chunked")
+		}
+		header = w.appendConnection(header)
+		header = append(header, "\r\n"...)
+		if _, err := w.writer.Write(header); err != nil {
+			return 0, err
+		}
+		w.state = stateBody
+	}
+	if w.head {
+		return len(p), nil
+	}
+	if !w.chunked {
+		return w.writer.Write(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := fmt.Appendf(nil, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+	if _, err := w.writer.Write(chunk); err != nil {
 		return 0, err
 	}
-	w.state = stateBody
-	return w.writer.Write(p)
+	return len(p), nil
 }
 
-func GetDefaultHeaders() headers.Headers {
+func GetDefaultHeaders() *headers.Headers {
 	h := headers.NewHeaders()
-	h.Set("connection", "close")
-	h.Set("content-type", "text/plain")
+	h.Set("Content-Type", "text/plain")
 
 	return h
 }
 
-func formatHeaderName(name string) string {
-	runes := []rune(name)
-	delimiter := '-'
-	capNext := true
-	for i, r := range runes {
-		if r == delimiter {
-			capNext = true
-			continue
-		}
-		if capNext {
-			runes[i] = unicode.ToUpper(r)
-			capNext = false
-		}
-	}
-
-	return string(runes)
+// HeaderWritten reports whether the status line has been written.
+func (w *response) HeaderWritten() bool {
+	return w.state != stateInit
 }
 
-func (w *response) Headers() headers.Headers {
+func (w *response) Headers() *headers.Headers {
 	return w.headers
 }
 
-func (w *response) Flush() {
+// Flush completes the response, so that the next one can follow it on the
+// same connection. If Flush writes the header, it returns the error of
+// WriteHeader for a field that had to be left out.
+func (w *response) Flush() error {
+	var err error
 	switch w.state {
+	case stateDone:
+		return nil
 	case stateBody:
-		return
-	case stateHeader:
-		w.writer.Write([]byte("\r\n"))
+		if w.chunked {
+			w.writer.Write([]byte("0\r\n\r\n"))
+		}
 	case stateInit:
-		w.WriteHeader(StatusOK)
-		w.writer.Write([]byte("\r\n"))
+		err = w.WriteHeader(StatusOK)
+		fallthrough
+	case stateHeader:
+		var header []byte
+		if w.bodyAllowed() && !w.head && w.headers.Get("content-length") == "" {
+			header = fmt.Appendf(header, "%s: %d\r\n", "Content-Length", 0)
+		}
+		header = w.appendConnection(header)
+		header = append(header, "\r\n"...)
+		w.writer.Write(header)
+	}
+	w.state = stateDone
+	return err
+}
+
+// appendConnection adds the Connection header held back from the header of
+// an HTTP/1.0 response until it is known whether the body ends with the
+// connection. It is checked like any other field, and left out if invalid.
+func (w *response) appendConnection(header []byte) []byte {
+	if v := w.headers.Get("connection"); w.http10 && v != "" {
+		header, _ = appendField(header, headers.Field{Name: "Connection", Value: v}, nil)
 	}
+	return header
+}
+
+func (w *response) bodyAllowed() bool {
+	return w.status != StatusNoContent && w.status != StatusNotModified
 }
@@ -3,6 +3,11 @@ package response
 type StatusCode int
 
 const (
+	StatusContinue           = 100
+	StatusSwitchingProtocols = 101
+	StatusProcessing         = 102
+	StatusEarlyHints         = 103
+
 	StatusOK        = 200
 	StatusCreated   = 201
 	StatusAccepted  = 202
@@ -14,24 +19,33 @@ const (
 	StatusTemporaryRedirect = 307
 	StatusPermanentRedirect = 308
 
-	StatusBadRequest            = 400
-	StatusUnauthorized          = 401
-	StatusForbidden             = 403
-	StatusNotFound              = 404
-	StatusMethodNotAllowed      = 405
-	StatusRequestTimeout        = 408
-	StatusConflict              = 409
-	StatusRequestEntityTooLarge = 413
-	StatusTooManyRequests       = 429
-
-	StatusInternalServerError = 500
-	StatusNotImplemented      = 501
-	StatusBadGateway          = 502
-	StatusServiceUnavailable  = 503
-	StatusGatewayTimeout      = 504
+	StatusBadRequest                  = 400
+	StatusUnauthorized                = 401
+	StatusForbidden                   = 403
+	StatusNotFound                    = 404
+	StatusMethodNotAllowed            = 405
+	StatusRequestTimeout              = 408
+	StatusConflict                    = 409
+	StatusRequestEntityTooLarge       = 413
+	StatusURITooLong                  = 414
+	StatusExpectationFailed           = 417
+	StatusTooManyRequests             = 429
+	StatusRequestHeaderFieldsTooLarge = 431
+
+	StatusInternalServerError     = 500
+	StatusNotImplemented          = 501
+	StatusBadGateway              = 502
+	StatusServiceUnavailable      = 503
+	StatusGatewayTimeout          = 504
+	StatusHTTPVersionNotSupported = 505
 )
 
 var statusMessage = map[StatusCode]string{
+	StatusContinue:           "Continue",
+	StatusSwitchingProtocols: "Switching Protocols",
+	StatusProcessing:         "Processing",
+	StatusEarlyHints:         "Early Hints",
+
 	StatusOK:        "OK",
 	StatusCreated:   "Created",
 	StatusAccepted:  "Accepted",
@@ -43,19 +57,23 @@ var statusMessage = map[StatusCode]string{
 	StatusTemporaryRedirect: "Temporary Redirect",
 	StatusPermanentRedirect: "Permanent Redirect",
 
-	StatusBadRequest:            "Bad Request",
-	StatusUnauthorized:          "Unauthorized",
-	StatusForbidden:             "Forbidden",
-	StatusNotFound:              "Not Found",
-	StatusMethodNotAllowed:      "Method Not Allowed",
-	StatusRequestTimeout:        "Request Timeout",
-	StatusConflict:              "Conflict",
-	StatusRequestEntityTooLarge: "Payload Too Large",
-	StatusTooManyRequests:       "Too Many Requests",
-
-	StatusInternalServerError: "Internal Server Error",
-	StatusNotImplemented:      "Not Implemented",
-	StatusBadGateway:          "Bad Gateway",
-	StatusServiceUnavailable:  "Service Unavailable",
-	StatusGatewayTimeout:      "Gateway Timeout",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusRequestEntityTooLarge:       "Payload Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+
+	StatusInternalServerError:     "Internal Server Error",
+	StatusNotImplemented:          "Not Implemented",
+	StatusBadGateway:              "Bad Gateway",
+	StatusServiceUnavailable:      "Service Unavailable",
+	StatusGatewayTimeout:          "Gateway Timeout",
+	StatusHTTPVersionNotSupported: "HTTP Version Not Supported",
 }
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// errBodyNotRequested ends a body the client was never asked to send with
+// 100 Continue. It is not waited for, so the connection cannot be reused.
+var errBodyNotRequested = errors.New("request body not requested")
+
+// trackedBody tells the connection when the handler is done with a request
+// body, so that the next request can be read after it.
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done chan struct{}
+	// err is set before done is closed; nil if the body was read or
+	// drained to its end.
+	err error
+
+	// sendContinue is called before the first read of a body the client
+	// only sends once it is asked to.
+	sendContinue func()
+	continueOnce sync.Once
+	requested    atomic.Bool
+}
+
+func newTrackedBody(body io.ReadCloser) *trackedBody {
+	return &trackedBody{
+		ReadCloser: body,
+		done:       make(chan struct{}),
+	}
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	if b.sendContinue != nil {
+		b.continueOnce.Do(func() {
+			b.requested.Store(true)
+			b.sendContinue()
+		})
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish(nil)
+	} else if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+// Close drains the rest of the body, unless the client is still waiting
+// for 100 Continue and so will not send it.
+func (b *trackedBody) Close() error {
+	if b.sendContinue != nil && !b.requested.Load() {
+		b.finish(errBodyNotRequested)
+		return errBodyNotRequested
+	}
+
+	err := b.ReadCloser.Close()
+	b.finish(err)
+	return err
+}
+
+func (b *trackedBody) finish(err error) {
+	b.once.Do(func() {
+		b.err = err
+		close(b.done)
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+)
+
+type ClientAuth int
+
+const (
+	// ClientCertNone does not ask for client certificates.
+	ClientCertNone ClientAuth = iota
+	// ClientCertOptional verifies a client certificate if one is sent.
+	ClientCertOptional
+	// ClientCertRequired rejects handshakes without a valid client
+	// certificate.
+	ClientCertRequired
+)
+
+var ErrNoClientCAs = errors.New("client certificate auth needs ClientCAs")
+
+func (a ClientAuth) tlsType() tls.ClientAuthType {
+	switch a {
+	case ClientCertOptional:
+		return tls.VerifyClientCertIfGiven
+	case ClientCertRequired:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// RequireClientCert only passes requests on to next when their verified
+// client certificate matches one of allowed. An entry matches the subject
+// common name, the full subject, or any DNS, email or URI SAN. Other
+// requests get 403.
+func RequireClientCert(next Handler, allowed ...string) Handler {
+	return func(w response.Writer, req *request.Request) {
+		chain := req.VerifiedChain()
+		if len(chain) == 0 || !certAllowed(chain[0], allowed) {
+			w.WriteHeader(response.StatusForbidden)
+			w.Write([]byte("client certificate not allowed"))
+			return
+		}
+		next(w, req)
+	}
+}
+
+func certAllowed(cert *x509.Certificate, allowed []string) bool {
+	names := []string{cert.Subject.CommonName, cert.Subject.String()}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, want := range allowed {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(name, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+)
+
+const (
+	DefaultIdleTimeout         = 2 * time.Minute
+	DefaultMaxRequestsPerConn  = 1000
+	DefaultMaxRequestLineBytes = 8 << 10
+	DefaultMaxHeaderBytes      = 1 << 20
+	DefaultMaxHeaderFields     = 100
+)
+
+// Config holds the settings of a Server. The zero value serves on a random
+// localhost port with the defaults below.
+type Config struct {
+	// Addr is the address to listen on, either a TCP host:port or a Unix
+	// socket as unix:///path/to.sock.
+	Addr string
+	// SocketMode is the file mode of a Unix socket. Zero means
+	// DefaultSocketMode.
+	SocketMode os.FileMode
+
+	// ReadHeaderTimeout bounds the time from the first byte of a request
+	// to the end of its header fields. Zero means ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time from the first byte of a request to the
+	// end of its body. Zero means no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time spent writing a response. Zero means
+	// no timeout.
+	WriteTimeout time.Duration
+	// HandlerTimeout is the deadline of the request context passed to the
+	// handler. Zero means no deadline.
+	HandlerTimeout time.Duration
+	// IdleTimeout is how long a connection is kept open waiting for the
+	// next request. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+
+	// MaxRequestLineBytes limits the request-line; longer ones get 414.
+	// Zero means DefaultMaxRequestLineBytes.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes limits the size of the request-line and header
+	// fields; larger ones get 431. Zero means DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+	// MaxHeaderFields limits the number of header fields; more get 431.
+	// Zero means DefaultMaxHeaderFields.
+	MaxHeaderFields int
+	// MaxBodyBytes limits the size of request bodies; larger ones get 413,
+	// or fail while the handler reads them. Zero means no limit.
+	MaxBodyBytes int64
+	// MaxRequestsPerConn is the number of requests served on a connection
+	// before it is closed. Zero means DefaultMaxRequestsPerConn.
+	MaxRequestsPerConn int
+
+	// TLSConfig configures the connections of ServeTLS.
+	TLSConfig *tls.Config
+	// ClientAuth asks TLS clients for certificates, which are verified
+	// against ClientCAs.
+	ClientAuth ClientAuth
+	ClientCAs  *x509.CertPool
+	// Certificates are certificate files served by ServeTLS in addition to
+	// the pair passed to it. The certificate is chosen by the server name
+	// the client sends (SNI), falling back to the first pair, and every
+	// pair is reloaded when its files change.
+	Certificates []CertPair
+	// CertReloadInterval is how often ServeTLS checks its certificate
+	// files for changes. Zero means DefaultCertReloadInterval.
+	CertReloadInterval time.Duration
+
+	// AllowedMethods limits the methods passed to the handler. Others get
+	// 405 with an Allow header if they are known to request.KnownMethod,
+	// or 501 otherwise. Empty means every method is passed.
+	AllowedMethods []string
+
+	// ErrorHandler writes the response to a request that could not be
+	// read, using the status code of err. Nil means the error message is
+	// sent as a plain-text body. The connection is closed afterwards,
+	// except after a method refused by AllowedMethods.
+	ErrorHandler func(w response.Writer, err *request.Error)
+
+	// PanicHandler is called with the recovered value and stack trace when
+	// a handler panics. Nil means both are written to ErrorLog.
+	PanicHandler func(req *request.Request, recovered any, stack []byte)
+
+	// ErrorLog receives errors from accepting connections and from
+	// handling requests. Nil means the standard logger.
+	ErrorLog *log.Logger
+}
+
+// Serve listens on c.Addr and serves the connections in the background.
+func (c Config) Serve(handler Handler) (*Server, error) {
+	listener, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ServeListener(listener, handler), nil
+}
+
+// ServeTLS is like Serve but speaks TLS. If certFile and keyFile are set,
+// that certificate is served together with c.Certificates, ahead of them,
+// and each is reloaded whenever its files change. Without any pair the
+// certificates of c.TLSConfig are used. The reloading stops when the
+// server is closed.
+func (c Config) ServeTLS(certFile, keyFile string, handler Handler) (*Server, error) {
+	tlsConfig := &tls.Config{}
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	}
+	if len(tlsConfig.NextProtos) == 0 {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+	if c.ClientAuth != ClientCertNone {
+		if c.ClientCAs == nil {
+			return nil, ErrNoClientCAs
+		}
+		tlsConfig.ClientAuth = c.ClientAuth.tlsType()
+		tlsConfig.ClientCAs = c.ClientCAs
+	}
+
+	pairs := c.Certificates
+	if certFile != "" || keyFile != "" {
+		pairs = append([]CertPair{{CertFile: certFile, KeyFile: keyFile}}, pairs...)
+	}
+	var certs *CertReloader
+	if len(pairs) > 0 {
+		var err error
+		certs, err = NewCertReloader(pairs...)
+		if err != nil {
+			return nil, err
+		}
+		certs.ErrorLog = c.ErrorLog
+		tlsConfig.GetCertificate = certs.GetCertificate
+	}
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil {
+		return nil, ErrNoCertificate
+	}
+
+	listener, err := c.listen()
+	if err != nil {
+		return nil, err
+	}
+
+	s := c.ServeListener(tls.NewListener(listener, tlsConfig), handler)
+	if certs != nil {
+		interval := c.CertReloadInterval
+		if interval == 0 {
+			interval = DefaultCertReloadInterval
+		}
+		certs.Watch(interval)
+		s.certs = certs
+	}
+	return s, nil
+}
+
+func (c Config) listen() (net.Listener, error) {
+	addr := c.Addr
+	if addr == "" {
+		addr = "localhost:0"
+	}
+	mode := c.SocketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	return Listen(addr, mode)
+}
+
+// ServeListener serves the connections accepted by listener in the
+// background. The listener is closed when the server is.
+func (c Config) ServeListener(listener net.Listener, handler Handler) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:      c.withDefaults(),
+		listener: listener,
+		handler:  handler,
+		conns:    make(map[*conn]struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	go s.listen()
+
+	return s
+}
+
+func (c Config) withDefaults() Config {
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = c.ReadTimeout
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.MaxRequestLineBytes == 0 {
+		c.MaxRequestLineBytes = DefaultMaxRequestLineBytes
+	}
+	if c.MaxHeaderBytes == 0 {
+		c.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if c.MaxHeaderFields == 0 {
+		c.MaxHeaderFields = DefaultMaxHeaderFields
+	}
+	if c.MaxRequestsPerConn == 0 {
+		c.MaxRequestsPerConn = DefaultMaxRequestsPerConn
+	}
+	if c.ErrorLog == nil {
+		c.ErrorLog = log.Default()
+	}
+	return c
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxPipelined bounds how many requests of one connection are handled at
+// the same time.
+const maxPipelined = 16
+
+// maxPipelineBuffer bounds the output a response buffers while it waits for
+// the responses before it. Past that, its writes block until its turn.
+const maxPipelineBuffer = 64 << 10
+
+type conn struct {
+	net.Conn
+	cfg      *Config
+	tlsState *tls.ConnectionState
+
+	mu       sync.Mutex
+	inflight int
+	unparsed bool
+	inBody   bool
+	started  time.Time
+}
+
+func newConn(c net.Conn, cfg *Config) *conn {
+	return &conn{Conn: c, cfg: cfg}
+}
+
+// Read marks the start of a request when its first byte arrives, which
+// is when the header timeout begins.
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.unparsed {
+			c.unparsed = true
+			c.started = time.Now()
+			c.setReadDeadline(c.cfg.ReadHeaderTimeout)
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// handshake completes the TLS handshake of a TLS connection within the
+// header timeout, or the idle timeout if there is none.
+func (c *conn) handshake() error {
+	tlsConn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	timeout := c.cfg.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = c.cfg.IdleTimeout
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	c.tlsState = &state
+	return nil
+}
+
+// waitForClose reads and discards until the connection fails, which tells
+// when a client that will send no more requests goes away.
+func (c *conn) waitForClose() {
+	buf := make([]byte, 512)
+	for {
+		if _, err := c.Conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// idle reports whether the connection is between requests: nothing is
+// being handled and no byte of the next request has arrived yet.
+func (c *conn) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.inflight == 0 && !c.unparsed
+}
+
+// waitForRequest arms the deadline for reading the next request. While
+// earlier requests are still being handled there is none; the last of
+// them arms the idle timeout when it finishes.
+func (c *conn) waitForRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.armWaitDeadline()
+}
+
+// startRequest marks a request whose header has been read as in flight.
+// Its body is read next, under the read timeout.
+func (c *conn) startRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inflight++
+	c.inBody = true
+	c.setReadDeadline(c.cfg.ReadTimeout)
+}
+
+// bodyRead marks the end of the body of the current request. buffered
+// tells whether bytes of a following request were already read along with
+// it.
+func (c *conn) bodyRead(buffered bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inBody = false
+	c.unparsed = buffered
+	if buffered {
+		c.started = time.Now()
+	}
+	c.SetReadDeadline(time.Time{})
+}
+
+func (c *conn) finishRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inflight--
+	c.armWaitDeadline()
+}
+
+func (c *conn) armWaitDeadline() {
+	switch {
+	case c.inBody:
+		c.setReadDeadline(c.cfg.ReadTimeout)
+	case c.unparsed:
+		c.setReadDeadline(c.cfg.ReadHeaderTimeout)
+	case c.inflight == 0:
+		c.SetReadDeadline(time.Now().Add(c.cfg.IdleTimeout))
+	default:
+		c.SetReadDeadline(time.Time{})
+	}
+}
+
+// setReadDeadline sets the read deadline to timeout after the start of the
+// current request, or clears it for a zero timeout.
+func (c *conn) setReadDeadline(timeout time.Duration) {
+	if timeout == 0 {
+		c.SetReadDeadline(time.Time{})
+		return
+	}
+	c.SetReadDeadline(c.started.Add(timeout))
+}
+
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// pipelineWriter keeps the responses of pipelined requests in request
+// order. Output is buffered, up to maxPipelineBuffer, until every earlier
+// response on the connection is complete and then goes straight to the
+// connection.
+type pipelineWriter struct {
+	conn *conn
+	prev <-chan struct{}
+	done chan struct{}
+	buf  bytes.Buffer
+	head bool
+}
+
+func newPipelineWriter(c *conn, prev <-chan struct{}) *pipelineWriter {
+	return &pipelineWriter{
+		conn: c,
+		prev: prev,
+		done: make(chan struct{}),
+	}
+}
+
+func (p *pipelineWriter) Write(b []byte) (int, error) {
+	if !p.head {
+		select {
+		case <-p.prev:
+		default:
+			if p.buf.Len()+len(b) <= maxPipelineBuffer {
+				return p.buf.Write(b)
+			}
+			<-p.prev
+		}
+		if err := p.takeHead(); err != nil {
+			return 0, err
+		}
+	}
+	return p.conn.Write(b)
+}
+
+func (p *pipelineWriter) takeHead() error {
+	p.head = true
+	if p.conn.cfg.WriteTimeout > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(p.conn.cfg.WriteTimeout))
+	}
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	_, err := p.buf.WriteTo(p.conn)
+	return err
+}
+
+// finish waits for the earlier responses, writes out whatever is still
+// buffered and lets the next response through. If closeConn is set the
+// connection is closed after the response.
+func (p *pipelineWriter) finish(closeConn bool) {
+	defer close(p.done)
+
+	if !p.head {
+		<-p.prev
+		p.takeHead()
+	}
+	if closeConn {
+		p.conn.Close()
+	}
+}
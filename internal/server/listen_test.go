@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "httpone.sock")
+
+	// Test: Stale socket file from a crashed server is replaced
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	s := startServerConfig(t, Config{Addr: "unix://" + path, SocketMode: 0o600}, func(w response.Writer, req *request.Request) {
+		w.Write([]byte("unix"))
+	})
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	conn := dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "unix", body)
+
+	// Test: Socket in use is not taken over
+	_, err = Listen("unix://"+path, DefaultSocketMode)
+	require.Error(t, err)
+
+	// Test: Socket file is removed on close
+	s.Close()
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestServeListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s := Config{ErrorLog: quietLog}.ServeListener(listener, func(w response.Writer, req *request.Request) {
+		w.Write([]byte("listener"))
+	})
+	defer s.Close()
+
+	conn := dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "listener", body)
+}
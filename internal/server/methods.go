@@ -0,0 +1,36 @@
+package server
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+)
+
+var (
+	ErrMethodNotAllowed     = errors.New("method not allowed")
+	ErrMethodNotImplemented = errors.New("method not implemented")
+)
+
+// checkMethod refuses methods missing from AllowedMethods: known ones with
+// 405, as the server could allow them, and unknown ones with 501.
+func (c *Config) checkMethod(method string) *request.Error {
+	if len(c.AllowedMethods) == 0 || slices.Contains(c.AllowedMethods, method) {
+		return nil
+	}
+	if request.KnownMethod(method) {
+		return &request.Error{StatusCode: response.StatusMethodNotAllowed, Err: ErrMethodNotAllowed}
+	}
+	return &request.Error{StatusCode: response.StatusNotImplemented, Err: ErrMethodNotImplemented}
+}
+
+// refuseMethod answers a request whose method is not allowed, listing the
+// allowed ones in the Allow header of a 405.
+func (s *Server) refuseMethod(w response.Writer, err *request.Error) {
+	if err.StatusCode == response.StatusMethodNotAllowed {
+		w.Headers().Set("Allow", strings.Join(s.cfg.AllowedMethods, ", "))
+	}
+	s.errorHandler()(w, err)
+}
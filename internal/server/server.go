@@ -2,73 +2,378 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/rizalta/httpone/internal/request"
 	"github.com/rizalta/httpone/internal/response"
 )
 
+const shutdownPollInterval = 50 * time.Millisecond
+
 type Handler func(w response.Writer, req *request.Request)
 
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	closed   atomic.Bool
+	cfg        Config
+	listener   net.Listener
+	handler    Handler
+	closed     atomic.Bool
+	inShutdown atomic.Bool
+	certs      *CertReloader
+
+	// ctx is the parent of all request contexts. It is canceled when the
+	// server is closed or starts shutting down.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[*conn]struct{}
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return nil, err
-	}
+	return Config{Addr: fmt.Sprintf("localhost:%d", port)}.Serve(handler)
+}
 
-	s := &Server{
-		listener: listener,
-		handler:  handler,
-	}
+func ServeListener(listener net.Listener, handler Handler) *Server {
+	return Config{}.ServeListener(listener, handler)
+}
 
-	go s.listen()
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
 
-	return s, nil
+func ServeTLS(port int, certFile, keyFile string, handler Handler) (*Server, error) {
+	return Config{Addr: fmt.Sprintf("localhost:%d", port)}.ServeTLS(certFile, keyFile, handler)
 }
 
 func (s *Server) Close() error {
 	if s.closed.CompareAndSwap(false, true) {
+		s.cancel()
+		if s.certs != nil {
+			s.certs.Close()
+		}
 		return s.listener.Close()
 	}
 	return nil
 }
 
+// Shutdown stops accepting connections and closes idle ones, then waits
+// for the active connections to finish their requests. When ctx is done
+// first the remaining connections are closed and an error reporting how
+// many were cut off is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	s.cancel()
+	err := s.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			n := s.closeAllConns()
+			if n == 0 {
+				return err
+			}
+			return fmt.Errorf("server: shutdown closed %d active connections: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) trackConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown.Load() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c)
+}
+
+// closeIdleConns closes the idle connections and reports whether no
+// connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if c.idle() {
+			c.Close()
+			delete(s.conns, c)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.conns)
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+	return n
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
 			if s.closed.Load() {
-				log.Println("server stopped")
+				s.cfg.ErrorLog.Println("server stopped")
 				return
 			}
-			log.Printf("error accepting conn, %v\n", err)
+			s.cfg.ErrorLog.Printf("error accepting conn, %v\n", err)
+			continue
+		}
+
+		c := newConn(conn, &s.cfg)
+		if !s.trackConn(c) {
+			c.Close()
 			continue
 		}
-		go s.handle(conn)
+		go s.handle(c)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+func (s *Server) handle(c *conn) {
+	defer s.untrackConn(c)
+	defer c.Close()
 
-	w := response.NewResponse(conn)
-	defer w.Flush()
+	if err := c.handshake(); err != nil {
+		s.cfg.ErrorLog.Printf("tls handshake error from %s, %v\n", c.RemoteAddr(), err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Runs before waiting for the handlers, so that they learn the client
+	// is gone.
+	defer cancel()
+
+	reader := request.NewReader(c)
+	reader.MaxRequestLineBytes = s.cfg.MaxRequestLineBytes
+	reader.MaxHeaderBytes = s.cfg.MaxHeaderBytes
+	reader.MaxHeaderFields = s.cfg.MaxHeaderFields
+	reader.MaxBodyBytes = s.cfg.MaxBodyBytes
+	pipeline := make(chan struct{}, maxPipelined)
+	prev := closedChan
+	// lastUnsafe is done once the latest request that may not run
+	// alongside others is.
+	lastUnsafe := closedChan
+
+	for served := 1; ; served++ {
+		c.waitForRequest()
+		req, err := reader.ReadRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) && c.idle() {
+				return
+			}
+			s.writeError(c, prev, err)
+			return
+		}
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
+		req.TLS = c.tlsState
+		req = req.WithContext(ctx)
+		keepAlive := req.KeepAlive() && served < s.cfg.MaxRequestsPerConn && !s.inShutdown.Load()
+		pw := newPipelineWriter(c, prev)
+		// A request waits for the unsafe requests before it, and an unsafe
+		// one for every request before it.
+		wait := lastUnsafe
+		if !concurrentMethod(req.RequestLine.Method) {
+			wait = prev
+			lastUnsafe = pw.done
+		}
+		prev = pw.done
+
+		var body *trackedBody
+		if req.Body != request.NoBody {
+			body = newTrackedBody(req.Body)
+			req.Body = body
+		}
+
+		pipeline <- struct{}{}
+		c.startRequest()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-pipeline }()
+			defer c.finishRequest()
+			defer req.Body.Close()
+
+			<-wait
+			s.serveRequest(pw, req, keepAlive)
+		}()
+
+		// The next request starts where this body ends, so wait for the
+		// handler to finish with it.
+		if body != nil {
+			<-body.done
+			if body.err != nil {
+				keepAlive = false
+			}
+		}
+		c.bodyRead(reader.Buffered() > 0)
+
+		if !keepAlive {
+			c.waitForClose()
+			return
+		}
+	}
+}
+
+// concurrentMethod reports whether a request with method may be handled
+// alongside the requests pipelined around it. Only the safe methods of RFC
+// 9110 may, since the others can change what the requests around them see.
+func concurrentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func (s *Server) serveRequest(pw *pipelineWriter, req *request.Request, keepAlive bool) {
+	if s.cfg.HandlerTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), s.cfg.HandlerTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	w := response.NewResponse(pw)
+	if req.HTTP10() {
+		w = response.NewHTTP10Response(pw)
+	}
+	w.SetRequestMethod(req.RequestLine.Method)
+	// A shutdown may start while the handler runs, so it is checked again
+	// when the header goes out. The connection then closes after this
+	// response.
+	w.SetBeforeHeader(func() {
+		if s.inShutdown.Load() {
+			w.Headers().Set("Connection", "close")
+		}
+	})
+	switch {
+	case !keepAlive:
+		w.Headers().Set("Connection", "close")
+	case req.HTTP10():
+		w.Headers().Set("Connection", "keep-alive")
+	}
+
+	if body, ok := req.Body.(*trackedBody); ok && req.ExpectContinue() {
+		// A final response sent before the body is asked for ends the
+		// connection, as the client may or may not send the body then.
+		w.Headers().Set("Connection", "close")
+		body.sendContinue = func() {
+			if w.HeaderWritten() {
+				return
+			}
+			if keepAlive {
+				w.Headers().Del("Connection")
+			}
+			w.WriteInformational(response.StatusContinue, nil)
+		}
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.recoverHandler(pw, req, recovered, w.HeaderWritten())
+		}
+	}()
+
+	if err := s.cfg.checkMethod(req.RequestLine.Method); err != nil {
+		s.refuseMethod(w, err)
+	} else {
+		s.handler(w, req)
+	}
+	if err := w.Flush(); err != nil {
+		s.cfg.ErrorLog.Printf("error writing response to %s %s, %v\n", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+	}
+	bodyErr := req.Body.Close()
+
+	pw.finish(!keepAlive || bodyErr != nil || strings.EqualFold(w.Headers().Get("connection"), "close"))
+}
+
+// recoverHandler reports a handler panic and ends the response. A response
+// that has not started yet becomes a 500; one that has is cut off by
+// closing the connection.
+func (s *Server) recoverHandler(pw *pipelineWriter, req *request.Request, recovered any, headerWritten bool) {
+	stack := debug.Stack()
+	if s.cfg.PanicHandler != nil {
+		s.cfg.PanicHandler(req, recovered, stack)
+	} else {
+		s.cfg.ErrorLog.Printf("panic serving %s %s, %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, recovered, stack)
+	}
+
+	if !headerWritten {
+		w := response.NewResponse(pw)
+		if req.HTTP10() {
+			w = response.NewHTTP10Response(pw)
+		}
+		w.SetRequestMethod(req.RequestLine.Method)
+		w.Headers().Set("Connection", "close")
 		w.WriteHeader(response.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		w.Flush()
+	}
+	pw.finish(true)
+}
+
+// writeError answers a request that could not be read and closes the
+// connection. Errors that leave nothing to answer are only logged.
+func (s *Server) writeError(c *conn, prev <-chan struct{}, err error) {
+	var reqErr *request.Error
+	if !errors.As(err, &reqErr) {
+		s.cfg.ErrorLog.Printf("error reading request from %s, %v\n", c.RemoteAddr(), err)
 		return
 	}
 
-	s.handler(w, req)
+	pw := newPipelineWriter(c, prev)
+	w := response.NewResponse(pw)
+	if reqErr.HTTP10() {
+		w = response.NewHTTP10Response(pw)
+	}
+	w.Headers().Set("Connection", "close")
+	s.errorHandler()(w, reqErr)
+	w.Flush()
+	pw.finish(true)
+}
+
+func (s *Server) errorHandler() func(w response.Writer, err *request.Error) {
+	if s.cfg.ErrorHandler != nil {
+		return s.cfg.ErrorHandler
+	}
+	return defaultErrorHandler
+}
+
+func defaultErrorHandler(w response.Writer, err *request.Error) {
+	w.WriteHeader(err.StatusCode)
+	w.Write([]byte(err.Error()))
 }
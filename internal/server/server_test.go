@@ -0,0 +1,949 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rizalta/httpone/internal/headers"
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var quietLog = log.New(io.Discard, "", 0)
+
+func startServer(t *testing.T, handler Handler) *Server {
+	t.Helper()
+	return startServerConfig(t, Config{}, handler)
+}
+
+func startServerConfig(t *testing.T, cfg Config, handler Handler) *Server {
+	t.Helper()
+	if cfg.ErrorLog == nil {
+		cfg.ErrorLog = quietLog
+	}
+	s, err := cfg.Serve(handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// logWriter passes each line of a log.Logger to the test.
+type logWriter chan string
+
+func (l logWriter) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial(s.Addr().Network(), s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) (string, map[string]string, string) {
+	t.Helper()
+	status, hdrs := readResponseHead(t, r)
+
+	var body strings.Builder
+	if hdrs["transfer-encoding"] == "chunked" {
+		for {
+			var size int
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			_, err = fmt.Sscanf(line, "%x", &size)
+			require.NoError(t, err)
+			chunk := make([]byte, size+2)
+			_, err = io.ReadFull(r, chunk)
+			require.NoError(t, err)
+			if size == 0 {
+				break
+			}
+			body.Write(chunk[:size])
+		}
+	} else if cl := hdrs["content-length"]; cl != "" {
+		var size int
+		_, err := fmt.Sscanf(cl, "%d", &size)
+		require.NoError(t, err)
+		chunk := make([]byte, size)
+		_, err = io.ReadFull(r, chunk)
+		require.NoError(t, err)
+		body.Write(chunk)
+	} else if strings.HasPrefix(status, "HTTP/1.0") && hdrs["connection"] == "close" {
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		body.Write(rest)
+	}
+
+	return status, hdrs, body.String()
+}
+
+// readResponseHead reads the status line and header fields, leaving the
+// body unread, as for a response to HEAD.
+func readResponseHead(t *testing.T, r *bufio.Reader) (string, map[string]string) {
+	t.Helper()
+	status, err := r.ReadString('\n')
+	require.NoError(t, err)
+
+	hdrs := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		hdrs[strings.ToLower(name)] = value
+	}
+
+	return strings.TrimRight(status, "\r\n"), hdrs
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Two requests on the same connection
+	for _, target := range []string{"/one", "/two"} {
+		_, err := io.WriteString(conn, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		status, hdrs, body := readResponse(t, r)
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		assert.Empty(t, hdrs["connection"])
+		assert.Equal(t, target, body)
+	}
+
+	// Test: Connection close ends the connection after the response
+	_, err := io.WriteString(conn, "GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	_, hdrs, body := readResponse(t, r)
+	assert.Equal(t, "close", hdrs["connection"])
+	assert.Equal(t, "/three", body)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHeadRequest(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.URL.Path == "/sized" {
+			w.Headers().Set("Content-Length", "5")
+		}
+		w.Write([]byte("hello"))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: HEAD responses carry no body, so the next response follows
+	_, err := io.WriteString(conn,
+		"HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"HEAD /sized HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	status, hdrs := readResponseHead(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Empty(t, hdrs["transfer-encoding"])
+	assert.Empty(t, hdrs["content-length"])
+
+	status, hdrs = readResponseHead(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "5", hdrs["content-length"])
+
+	status, _, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "hello", body)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWriteHeaderTwice(t *testing.T) {
+	errs := make(chan error, 2)
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		w.WriteHeader(response.StatusOK)
+		errs <- w.WriteHeader(response.StatusNotFound)
+		w.Write([]byte("body"))
+		errs <- w.WriteHeader(response.StatusNotFound)
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Later calls are refused and the connection stays in step
+	for range 2 {
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		status, _, body := readResponse(t, r)
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		assert.Equal(t, "body", body)
+		assert.ErrorIs(t, <-errs, response.ErrHeaderWritten)
+		assert.ErrorIs(t, <-errs, response.ErrHeaderWritten)
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	for range 2 {
+		status, hdrs, body := readResponse(t, r)
+		assert.Equal(t, "HTTP/1.1 200 OK", status)
+		assert.Equal(t, "0", hdrs["content-length"])
+		assert.Empty(t, body)
+	}
+}
+
+func TestPipelinedResponsesInOrder(t *testing.T) {
+	release := make(chan struct{})
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+		}
+		w.Write([]byte(req.RequestLine.RequestTarget))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: The first response holds back the ones behind it
+	_, err := io.WriteString(conn,
+		"GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for _, target := range []string{"/slow", "/fast", "/last"} {
+		_, _, body := readResponse(t, r)
+		assert.Equal(t, target, body)
+	}
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPipelinedUnsafeMethods(t *testing.T) {
+	var running, most atomic.Int32
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Pipelined POSTs and the GET after them run one at a time
+	_, err := io.WriteString(conn,
+		"POST /1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"+
+			"POST /2 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"+
+			"POST /3 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"+
+			"GET /4 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	for _, want := range []string{"POST /1", "POST /2", "POST /3", "GET /4"} {
+		_, _, body := readResponse(t, r)
+		assert.Equal(t, want, body)
+	}
+	assert.Equal(t, int32(1), most.Load())
+
+	// Test: Pipelined GETs still run together
+	most.Store(0)
+	_, err = io.WriteString(conn,
+		"GET /5 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /6 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /7 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	for _, want := range []string{"GET /5", "GET /6", "GET /7"} {
+		_, _, body := readResponse(t, r)
+		assert.Equal(t, want, body)
+	}
+	assert.Greater(t, most.Load(), int32(1))
+}
+
+func TestPipelineBuffer(t *testing.T) {
+	release := make(chan struct{})
+	written := make(chan struct{})
+	big := strings.Repeat("x", 4*maxPipelineBuffer)
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+			w.Write([]byte("slow"))
+			return
+		}
+		w.Write([]byte(big))
+		close(written)
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	_, err := io.WriteString(conn,
+		"GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"GET /big HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: A large response behind a slow one waits instead of buffering
+	select {
+	case <-written:
+		t.Fatal("response buffered past the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, "slow", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, big, body)
+	<-written
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("done"))
+	})
+
+	idle := dial(t, s)
+	active := dial(t, s)
+	r := bufio.NewReader(active)
+	_, err := io.WriteString(active, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	// Test: Shutdown waits for the active request and closes the idle conn
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+
+	_, err = bufio.NewReader(idle).ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: The request in flight is answered with Connection: close
+	close(release)
+	_, hdrs, body := readResponse(t, r)
+	assert.Equal(t, "done", body)
+	assert.Equal(t, "close", hdrs["connection"])
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, <-shutdownErr)
+
+	_, err = net.Dial(s.Addr().Network(), s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+
+	// Test: Connections still active at the deadline are cut off
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "1 active connections")
+
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestTimeouts(t *testing.T) {
+	s := startServerConfig(t, Config{
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		IdleTimeout:       100 * time.Millisecond,
+	}, func(w response.Writer, req *request.Request) {})
+
+	// Test: Slow header gets 408
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: loc")
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	status, hdrs, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", status)
+	assert.Equal(t, "close", hdrs["connection"])
+
+	// Test: Idle connection is closed without a response
+	conn = dial(t, s)
+	start := time.Now()
+	_, err = bufio.NewReader(conn).ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	s := startServerConfig(t, Config{MaxHeaderBytes: 64}, func(w response.Writer, req *request.Request) {})
+	conn := dial(t, s)
+
+	// Test: Header over the limit gets 431
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: "+strings.Repeat("a", 100)+"\r\n\r\n")
+	require.NoError(t, err)
+	status, _, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 431 Request Header Fields Too Large", status)
+}
+
+func TestErrorHandler(t *testing.T) {
+	// Test: Default error response
+	s := startServer(t, func(w response.Writer, req *request.Request) {})
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 505 HTTP Version Not Supported", status)
+	assert.Equal(t, "close", hdrs["connection"])
+
+	// Test: Custom error body
+	s = startServerConfig(t, Config{
+		ErrorHandler: func(w response.Writer, err *request.Error) {
+			w.Headers().Set("content-type", "application/json")
+			w.WriteHeader(err.StatusCode)
+			fmt.Fprintf(w, `{"status":%d}`, err.StatusCode)
+		},
+	}, func(w response.Writer, req *request.Request) {})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 400 Bad Request", status)
+	assert.Equal(t, "application/json", hdrs["content-type"])
+	assert.Equal(t, `{"status":400}`, body)
+}
+
+func TestHandlerPanic(t *testing.T) {
+	panics := make(chan any, 2)
+	s := startServerConfig(t, Config{
+		PanicHandler: func(req *request.Request, recovered any, stack []byte) {
+			assert.NotEmpty(t, stack)
+			panics <- recovered
+		},
+	}, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/late" {
+			w.WriteHeader(response.StatusOK)
+			w.Write([]byte("partial"))
+		}
+		panic("boom " + req.RequestLine.RequestTarget)
+	})
+
+	// Test: Panic before the header gets a 500
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error", status)
+	assert.Equal(t, "close", hdrs["connection"])
+	assert.Equal(t, "boom /early", <-panics)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Panic after the header aborts the connection
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	out, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(out), "partial")
+	assert.NotContains(t, string(out), "0\r\n\r\n")
+	assert.Equal(t, "boom /late", <-panics)
+}
+
+func TestRequestContext(t *testing.T) {
+	started := make(chan struct{}, 1)
+	canceled := make(chan error, 1)
+	s := startServerConfig(t, Config{HandlerTimeout: time.Second}, func(w response.Writer, req *request.Request) {
+		_, hasDeadline := req.Context().Deadline()
+		assert.True(t, hasDeadline)
+		if req.RequestLine.RequestTarget == "/quick" {
+			return
+		}
+		started <- struct{}{}
+		<-req.Context().Done()
+		canceled <- req.Context().Err()
+	})
+
+	// Test: Context canceled when the client goes away
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-canceled, context.Canceled)
+
+	// Test: Context canceled when shutdown starts
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	<-started
+	go s.Shutdown(context.Background())
+	assert.ErrorIs(t, <-canceled, context.Canceled)
+
+	// Test: Handler timeout sets the deadline
+	s = startServerConfig(t, Config{HandlerTimeout: 50 * time.Millisecond}, func(w response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		canceled <- req.Context().Err()
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-canceled, context.DeadlineExceeded)
+}
+
+func TestStreamingBody(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/ignore" {
+			w.Write([]byte("ignored"))
+			return
+		}
+		n, err := io.Copy(io.Discard, req.Body)
+		assert.NoError(t, err)
+		fmt.Fprintf(w, "%d %s", n, req.Trailers.Get("checksum"))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Chunked upload read by the handler, then a pipelined request
+	_, err := io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"3\r\nabc\r\n4\r\ndefg\r\n0\r\nChecksum: c0ffee\r\n\r\n"+
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nxyz")
+	require.NoError(t, err)
+	_, _, body := readResponse(t, r)
+	assert.Equal(t, "7 c0ffee", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "3 ", body)
+
+	// Test: Body the handler ignores is drained
+	_, err = io.WriteString(conn, "POST /ignore HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"+
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	require.NoError(t, err)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "ignored", body)
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "2 ", body)
+
+	// Test: Large ignored body closes the connection
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	go func() {
+		io.WriteString(conn, "POST /ignore HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10000000\r\n\r\n")
+		io.Copy(conn, io.LimitReader(neverEnding('x'), 10000000))
+	}()
+	_, _, body = readResponse(t, r)
+	assert.Equal(t, "ignored", body)
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestRequestLimits(t *testing.T) {
+	s := startServerConfig(t, Config{
+		MaxRequestLineBytes: 64,
+		MaxHeaderFields:     2,
+		MaxBodyBytes:        8,
+	}, func(w response.Writer, req *request.Request) {
+		_, err := io.ReadAll(req.Body)
+		var reqErr *request.Error
+		if errors.As(err, &reqErr) {
+			w.WriteHeader(reqErr.StatusCode)
+		}
+	})
+
+	for _, tt := range []struct {
+		data   string
+		status string
+	}{
+		{"GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\n\r\n", "HTTP/1.1 414 URI Too Long"},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\n\r\n", "HTTP/1.1 431 Request Header Fields Too Large"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n123456789", "HTTP/1.1 413 Payload Too Large"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n9\r\n123456789\r\n0\r\n\r\n", "HTTP/1.1 413 Payload Too Large"},
+	} {
+		conn := dial(t, s)
+		_, err := io.WriteString(conn, tt.data)
+		require.NoError(t, err)
+		status, _, _ := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, status)
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	s := startServerConfig(t, Config{
+		AllowedMethods: []string{"GET", "HEAD", "PURGE"},
+	}, func(w response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.Method))
+	})
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+
+	// Test: Extension method in the allow-list reaches the handler
+	_, err := io.WriteString(conn, "PURGE /cached HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, _, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "PURGE", body)
+
+	// Test: Known method outside the allow-list gets 405 with Allow
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	status, hdrs, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed", status)
+	assert.Equal(t, "GET, HEAD, PURGE", hdrs["allow"])
+	assert.Empty(t, hdrs["connection"])
+
+	// Test: Unknown method gets 501 on the same connection
+	_, err = io.WriteString(conn, "BREW / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 501 Not Implemented", status)
+	assert.Empty(t, hdrs["allow"])
+
+	// Test: Registered method gets 405
+	request.RegisterMethod("PROPFIND")
+	_, err = io.WriteString(conn, "PROPFIND / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed", status)
+
+	// Test: No allow-list passes every method
+	s = startServer(t, func(w response.Writer, req *request.Request) {
+		w.Write([]byte(req.RequestLine.Method))
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, _, body = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "BREW", body)
+}
+
+func TestHTTP10(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.URL.Path == "/sized" {
+			w.Headers().Set("content-length", "5")
+		}
+		w.Write([]byte("hello"))
+	})
+
+	// Test: Body delimited by closing the connection
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "close", hdrs["connection"])
+	assert.Empty(t, hdrs["transfer-encoding"])
+	assert.Equal(t, "hello", body)
+
+	// Test: Keep-alive with a sized body, then a close-delimited one
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET /sized HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, body = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "keep-alive", hdrs["connection"])
+	assert.Equal(t, "hello", body)
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, body = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "close", hdrs["connection"])
+	assert.Equal(t, "hello", body)
+
+	// Test: Error responses keep the version of HTTP/1.0 requests
+	s = startServerConfig(t, Config{
+		PanicHandler: func(*request.Request, any, []byte) {},
+	}, func(w response.Writer, req *request.Request) {
+		panic("boom")
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.0 500 Internal Server Error", status)
+
+	// Test: Requests that cannot be read are answered without chunking
+	s = startServerConfig(t, Config{MaxBodyBytes: 4}, func(w response.Writer, req *request.Request) {})
+	for _, tt := range []struct {
+		data   string
+		status string
+	}{
+		{"POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", "HTTP/1.0 400 Bad Request"},
+		{"POST / HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello", "HTTP/1.0 413 Payload Too Large"},
+		{"GET /a%zz HTTP/1.0\r\n\r\n", "HTTP/1.0 400 Bad Request"},
+	} {
+		conn = dial(t, s)
+		_, err = io.WriteString(conn, tt.data)
+		require.NoError(t, err)
+		status, hdrs, body = readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, status, tt.data)
+		assert.Empty(t, hdrs["transfer-encoding"], tt.data)
+		assert.Equal(t, "close", hdrs["connection"], tt.data)
+		assert.NotEmpty(t, body, tt.data)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		if req.URL.Path == "/reject" {
+			w.WriteHeader(response.StatusRequestEntityTooLarge)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		w.Write(body)
+	})
+
+	// Test: 100 Continue is sent when the handler reads the body
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	require.NoError(t, err)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	_, err = io.WriteString(conn, "hello")
+	require.NoError(t, err)
+	status, hdrs, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Empty(t, hdrs["connection"])
+	assert.Equal(t, "hello", body)
+
+	// Test: The connection is kept open afterwards
+	_, err = io.WriteString(conn, "POST /again HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nok")
+	require.NoError(t, err)
+	status, _, body = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "ok", body)
+
+	// Test: Early final response without the body ever being sent
+	conn = dial(t, s)
+	r = bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "POST /reject HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 1000000\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, _ = readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 413 Payload Too Large", status)
+	assert.Equal(t, "close", hdrs["connection"])
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unknown expectation
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 200-ok\r\nContent-Length: 5\r\n\r\nhello")
+	require.NoError(t, err)
+	status, _, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed", status)
+}
+
+func TestInformationalResponses(t *testing.T) {
+	errs := make(chan error, 3)
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		hints := headers.NewHeaders()
+		hints.Add("Link", "</style.css>; rel=preload; as=style")
+		hints.Add("Link", "</script.js>; rel=preload; as=script")
+		errs <- w.WriteInformational(response.StatusEarlyHints, hints)
+
+		w.WriteHeader(response.StatusProcessing)
+		w.Headers().Set("content-type", "text/html")
+		w.Write([]byte("<html></html>"))
+
+		errs <- w.WriteInformational(response.StatusEarlyHints, nil)
+		errs <- w.WriteInformational(response.StatusOK, nil)
+	})
+
+	// Test: Interim responses with their own fields before the final one
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+
+	var hints strings.Builder
+	for !strings.HasSuffix(hints.String(), "\r\n\r\n") {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		hints.WriteString(line)
+	}
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>; rel=preload; as=style\r\n"+
+		"Link: </script.js>; rel=preload; as=script\r\n\r\n", hints.String())
+	status, hdrs, _ := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 102 Processing", status)
+	assert.Empty(t, hdrs)
+	status, hdrs, body := readResponse(t, r)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "text/html", hdrs["content-type"])
+	assert.Empty(t, hdrs["link"])
+	assert.Equal(t, "<html></html>", body)
+
+	require.NoError(t, <-errs)
+	assert.ErrorIs(t, <-errs, response.ErrHeaderWritten)
+	assert.ErrorIs(t, <-errs, response.ErrNotInformational)
+
+	// Test: Nothing is sent to HTTP/1.0 clients
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	status, _, body = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.0 200 OK", status)
+	assert.Equal(t, "<html></html>", body)
+}
+
+func TestRepeatedResponseFields(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		w.Headers().Add("Set-Cookie", "a=1; Path=/")
+		w.Headers().Add("Set-Cookie", "b=2; Path=/")
+		w.WriteHeader(response.StatusNoContent)
+	})
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "Set-Cookie: a=1; Path=/\r\n")
+	assert.Contains(t, string(raw), "Set-Cookie: b=2; Path=/\r\n")
+}
+
+func TestResponseFieldOrder(t *testing.T) {
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		w.Headers().Add("X-Request-ID", "42")
+		w.Headers().Add("x-forwarded-for", "10.0.0.1")
+		w.Headers().Add("ETag", `"v1"`)
+		w.Headers().Set("Content-Length", "5")
+		w.Write([]byte("hello"))
+	})
+
+	// Test: Fields are written in order with their case, every time
+	for range 3 {
+		conn := dial(t, s)
+		_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		require.NoError(t, err)
+		raw, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+			"Content-Type: text/plain\r\n"+
+			"Connection: close\r\n"+
+			"X-Request-ID: 42\r\n"+
+			"x-forwarded-for: 10.0.0.1\r\n"+
+			"ETag: \"v1\"\r\n"+
+			"Content-Length: 5\r\n"+
+			"\r\n"+
+			"hello", string(raw))
+	}
+}
+
+func TestResponseSplitting(t *testing.T) {
+	errs := make(chan error, 1)
+	s := startServer(t, func(w response.Writer, req *request.Request) {
+		w.Headers().Set("Location", "/next\r\nSet-Cookie: session=stolen")
+		w.Headers().Set("X-Safe", "kept")
+		errs <- w.WriteHeader(response.StatusFound)
+	})
+	conn := dial(t, s)
+	_, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+
+	// Test: The invalid field is left out and reported
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-errs, headers.ErrInvalidHeaderValue)
+	assert.NotContains(t, string(raw), "Set-Cookie")
+	assert.NotContains(t, string(raw), "Location")
+	assert.Contains(t, string(raw), "X-Safe: kept\r\n")
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 302 Found\r\n"))
+
+	// Test: A header written by Write reports the field, and the body is sent
+	s = startServer(t, func(w response.Writer, req *request.Request) {
+		w.Headers().Set("Location", "/next\r\nSet-Cookie: session=stolen")
+		_, err := w.Write([]byte("body"))
+		errs <- err
+	})
+	conn = dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, body := readResponse(t, r)
+	assert.ErrorIs(t, <-errs, headers.ErrInvalidHeaderValue)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Empty(t, hdrs["location"])
+	assert.Empty(t, hdrs["set-cookie"])
+	assert.Equal(t, "body", body)
+
+	// Test: A header written when the handler returns is logged
+	logs := make(logWriter, 1)
+	s = startServerConfig(t, Config{ErrorLog: log.New(logs, "", 0)}, func(w response.Writer, req *request.Request) {
+		w.Headers().Set("Location", "/next\r\nSet-Cookie: session=stolen")
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET /quiet HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	status, hdrs, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Empty(t, hdrs["location"])
+	line := <-logs
+	assert.Contains(t, line, "GET /quiet")
+	assert.Contains(t, line, headers.ErrInvalidHeaderValue.Error())
+
+	// Test: The Connection field held back from an HTTP/1.0 header
+	s = startServer(t, func(w response.Writer, req *request.Request) {
+		w.Headers().Set("Connection", "keep-alive\r\nX-Evil: 1")
+	})
+	conn = dial(t, s)
+	_, err = io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	raw, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.0 200 OK\r\n"))
+	assert.NotContains(t, string(raw), "X-Evil")
+	assert.NotContains(t, string(raw), "Connection")
+}
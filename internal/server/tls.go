@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DefaultCertReloadInterval = 30 * time.Second
+
+var ErrNoCertificate = errors.New("no tls certificate configured")
+
+// CertPair names the PEM files of a certificate chain and its key.
+type CertPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+type loadedCert struct {
+	pair    CertPair
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// CertReloader serves certificates from files and loads them again when
+// the files change. With several pairs, the certificate is chosen by the
+// server name the client sends (SNI); the first pair is the fallback.
+type CertReloader struct {
+	// ErrorLog receives errors from reloading. Nil means the standard
+	// logger.
+	ErrorLog *log.Logger
+
+	mu    sync.RWMutex
+	certs []loadedCert
+	stop  chan struct{}
+	once  sync.Once
+}
+
+func NewCertReloader(pairs ...CertPair) (*CertReloader, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificate pairs")
+	}
+
+	r := &CertReloader{
+		certs: make([]loadedCert, len(pairs)),
+		stop:  make(chan struct{}),
+	}
+	for i, pair := range pairs {
+		loaded, err := loadCert(pair)
+		if err != nil {
+			return nil, err
+		}
+		r.certs[i] = loaded
+	}
+	return r, nil
+}
+
+func loadCert(pair CertPair) (loadedCert, error) {
+	modTime, err := pairModTime(pair)
+	if err != nil {
+		return loadedCert{}, err
+	}
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		return loadedCert{}, fmt.Errorf("load certificate %s: %w", pair.CertFile, err)
+	}
+	return loadedCert{pair: pair, cert: &cert, modTime: modTime}, nil
+}
+
+// pairModTime returns the later modification time of the two files.
+func pairModTime(pair CertPair) (time.Time, error) {
+	certInfo, err := os.Stat(pair.CertFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(pair.KeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if hello.ServerName == "" {
+		return r.certs[0].cert, nil
+	}
+	for _, loaded := range r.certs {
+		if hello.SupportsCertificate(loaded.cert) == nil {
+			return loaded.cert, nil
+		}
+	}
+	return r.certs[0].cert, nil
+}
+
+// Reload loads the pairs whose files changed since they were last loaded.
+// A pair that fails to load keeps its previous certificate.
+func (r *CertReloader) Reload() error {
+	r.mu.RLock()
+	certs := make([]loadedCert, len(r.certs))
+	copy(certs, r.certs)
+	r.mu.RUnlock()
+
+	var errs []error
+	for i, loaded := range certs {
+		modTime, err := pairModTime(loaded.pair)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if modTime.Equal(loaded.modTime) {
+			continue
+		}
+		reloaded, err := loadCert(loaded.pair)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		r.mu.Lock()
+		r.certs[i] = reloaded
+		r.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Watch calls Reload every interval until Close is called.
+func (r *CertReloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				if err := r.Reload(); err != nil {
+					r.logger().Printf("error reloading certificates, %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *CertReloader) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return nil
+}
+
+func (r *CertReloader) logger() *log.Logger {
+	if r.ErrorLog == nil {
+		return log.Default()
+	}
+	return r.ErrorLog
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizalta/httpone/internal/request"
+	"github.com/rizalta/httpone/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair CertPair
+}
+
+// newTestCert creates a certificate for names signed by parent, or a
+// self-signed CA when parent is nil, and writes it to dir as PEM files.
+func newTestCert(t *testing.T, dir, commonName string, parent *testCert, names ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pair := CertPair{
+		CertFile: filepath.Join(dir, commonName+".crt"),
+		KeyFile:  filepath.Join(dir, commonName+".key"),
+	}
+	writeCertFiles(t, pair, der, keyDER)
+
+	return &testCert{cert: cert, key: key, pair: pair}
+}
+
+func writeCertFiles(t *testing.T, pair CertPair, der, keyDER []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func (c *testCert) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+func dialTLS(t *testing.T, s *Server, cfg *tls.Config) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial(s.Addr().Network(), s.Addr().String(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	leaf := newTestCert(t, dir, "leaf", ca, "localhost")
+
+	s, err := Config{ErrorLog: quietLog}.ServeTLS(leaf.pair.CertFile, leaf.pair.KeyFile, func(w response.Writer, req *request.Request) {
+		if !assert.NotNil(t, req.TLS) {
+			return
+		}
+		w.Write([]byte(tls.VersionName(req.TLS.Version)))
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Request carries the negotiated TLS state
+	conn := dialTLS(t, s, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", MinVersion: tls.VersionTLS13, NextProtos: []string{"http/1.1"}})
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, _, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, "TLS 1.3", body)
+	assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+
+	// Test: No certificate at all
+	_, err = Config{ErrorLog: quietLog}.ServeTLS("", "", func(w response.Writer, req *request.Request) {})
+	assert.ErrorIs(t, err, ErrNoCertificate)
+}
+
+func TestCertReloaderSNI(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	first := newTestCert(t, dir, "first", ca, "first.test")
+	second := newTestCert(t, dir, "second", ca, "second.test", "*.second.test")
+
+	s, err := Config{ErrorLog: quietLog, Certificates: []CertPair{second.pair}}.ServeTLS(first.pair.CertFile, first.pair.KeyFile, func(w response.Writer, req *request.Request) {})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Certificate picked by server name
+	for name, want := range map[string]*testCert{
+		"first.test":      first,
+		"second.test":     second,
+		"api.second.test": second,
+	} {
+		conn := dialTLS(t, s, &tls.Config{RootCAs: ca.pool(), ServerName: name})
+		assert.Equal(t, want.cert.Raw, conn.ConnectionState().PeerCertificates[0].Raw, name)
+	}
+
+	// Test: Unknown name falls back to the first pair
+	conn := dialTLS(t, s, &tls.Config{InsecureSkipVerify: true, ServerName: "other.test"})
+	assert.Equal(t, first.cert.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+
+	// Test: Pairs from Certificates alone are reloaded, and stop on Close
+	s, err = Config{ErrorLog: quietLog, Certificates: []CertPair{first.pair, second.pair}, CertReloadInterval: 10 * time.Millisecond}.ServeTLS("", "", func(w response.Writer, req *request.Request) {})
+	require.NoError(t, err)
+	defer s.Close()
+
+	renewed := newTestCert(t, t.TempDir(), "second", ca, "second.test")
+	keyDER, err := x509.MarshalECPrivateKey(renewed.key)
+	require.NoError(t, err)
+	writeCertFiles(t, second.pair, renewed.cert.Raw, keyDER)
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(second.pair.CertFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		conn, err := tls.Dial(s.Addr().Network(), s.Addr().String(), &tls.Config{RootCAs: ca.pool(), ServerName: "second.test"})
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		return string(conn.ConnectionState().PeerCertificates[0].Raw) == string(renewed.cert.Raw)
+	}, time.Second, 10*time.Millisecond)
+
+	require.NotNil(t, s.certs)
+	s.Close()
+	select {
+	case <-s.certs.stop:
+	default:
+		t.Error("reloader still running after Close")
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	leaf := newTestCert(t, dir, "leaf", ca, "localhost")
+
+	s, err := Config{ErrorLog: quietLog, CertReloadInterval: 10 * time.Millisecond}.ServeTLS(leaf.pair.CertFile, leaf.pair.KeyFile, func(w response.Writer, req *request.Request) {})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn := dialTLS(t, s, &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"})
+	assert.Equal(t, leaf.cert.Raw, conn.ConnectionState().PeerCertificates[0].Raw)
+
+	// Test: New certificate is served after the files change
+	renewed := newTestCert(t, t.TempDir(), "leaf", ca, "localhost")
+	der := renewed.cert.Raw
+	keyDER, err := x509.MarshalECPrivateKey(renewed.key)
+	require.NoError(t, err)
+	writeCertFiles(t, leaf.pair, der, keyDER)
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(leaf.pair.CertFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		conn, err := tls.Dial(s.Addr().Network(), s.Addr().String(), &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"})
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		return string(conn.ConnectionState().PeerCertificates[0].Raw) == string(der)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientCertAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	leaf := newTestCert(t, dir, "leaf", ca, "localhost")
+	alice := newTestCert(t, dir, "alice", ca, "alice.internal")
+	mallory := newTestCert(t, dir, "mallory", ca, "mallory.internal")
+
+	handler := RequireClientCert(func(w response.Writer, req *request.Request) {
+		w.Write([]byte(req.ClientSubject()))
+	}, "alice.internal")
+
+	clientConfig := func(c *testCert) *tls.Config {
+		cfg := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost"}
+		if c != nil {
+			cert, err := tls.LoadX509KeyPair(c.pair.CertFile, c.pair.KeyFile)
+			require.NoError(t, err)
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		return cfg
+	}
+	get := func(s *Server, c *testCert) (string, string, error) {
+		conn, err := tls.Dial(s.Addr().Network(), s.Addr().String(), clientConfig(c))
+		if err != nil {
+			return "", "", err
+		}
+		defer conn.Close()
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+			return "", "", err
+		}
+		r := bufio.NewReader(conn)
+		if _, err := r.Peek(1); err != nil {
+			return "", "", err
+		}
+		status, _, body := readResponse(t, r)
+		return status, body, nil
+	}
+
+	// Test: Missing CA pool
+	_, err := Config{ClientAuth: ClientCertRequired}.ServeTLS(leaf.pair.CertFile, leaf.pair.KeyFile, handler)
+	require.ErrorIs(t, err, ErrNoClientCAs)
+
+	required, err := Config{ErrorLog: quietLog, ClientAuth: ClientCertRequired, ClientCAs: ca.pool()}.ServeTLS(leaf.pair.CertFile, leaf.pair.KeyFile, handler)
+	require.NoError(t, err)
+	defer required.Close()
+
+	// Test: Allowed certificate, subject on the request
+	status, body, err := get(required, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+	assert.Equal(t, "CN=alice", body)
+
+	// Test: Valid certificate not on the allow-list
+	status, _, err = get(required, mallory)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden", status)
+
+	// Test: No certificate fails the handshake
+	_, _, err = get(required, nil)
+	assert.Error(t, err)
+
+	optional, err := Config{ErrorLog: quietLog, ClientAuth: ClientCertOptional, ClientCAs: ca.pool()}.ServeTLS(leaf.pair.CertFile, leaf.pair.KeyFile, handler)
+	require.NoError(t, err)
+	defer optional.Close()
+
+	// Test: Optional certificate, none sent
+	status, _, err = get(optional, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 403 Forbidden", status)
+
+	// Test: Optional certificate, allowed one sent
+	status, _, err = get(optional, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK", status)
+}